@@ -0,0 +1,190 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/gorilla/mux"
+	"github.com/sinderpl/AsyncTaskProcessor/queue"
+	"github.com/sinderpl/AsyncTaskProcessor/storage"
+	"github.com/sinderpl/AsyncTaskProcessor/task"
+)
+
+// Package api/admin deals with operating the queues at runtime such as creating, resizing and pausing them
+
+type WorkerPoolPayload struct {
+	Size int `json:"size"`
+}
+
+type WorkerPoolResponse struct {
+	Queue string `json:"queue"`
+	Size  int    `json:"size"`
+}
+
+type QueuesResponse struct {
+	Queues          []queue.QueueInfo `json:"queues"`
+	PausedTaskTypes []task.TypeOf     `json:"pausedTaskTypes"`
+}
+
+type PauseResponse struct {
+	Queue    string      `json:"queue,omitempty"`
+	TaskType task.TypeOf `json:"taskType,omitempty"`
+	Paused   bool        `json:"paused"`
+}
+
+// queueName returns the queue of the request path, requests without one act on the default queue
+func queueName(r *http.Request) string {
+	if name, ok := mux.Vars(r)["name"]; ok {
+		return name
+	}
+
+	return queue.DefaultQueue
+}
+
+func (s *server) handleGetWorkerPool(w http.ResponseWriter, r *http.Request) error {
+	if s.queue == nil {
+		return writeJson(w, http.StatusServiceUnavailable, errorResponse{Error: "queue is not available"})
+	}
+
+	name := queueName(r)
+	size, err := s.queue.WorkerPoolSize(name)
+	if err != nil {
+		return writeJson(w, http.StatusNotFound, errorResponse{Error: err.Error()})
+	}
+
+	return writeJson(w, http.StatusOK, WorkerPoolResponse{Queue: name, Size: size})
+}
+
+func (s *server) handleResizeWorkerPool(w http.ResponseWriter, r *http.Request) error {
+	if s.queue == nil {
+		return writeJson(w, http.StatusServiceUnavailable, errorResponse{Error: "queue is not available"})
+	}
+
+	req := new(WorkerPoolPayload)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return writeJson(w, http.StatusBadRequest, errorResponse{Error: "failed to decode request body"})
+	}
+
+	if req.Size < 1 {
+		return writeJson(w, http.StatusBadRequest, errorResponse{Error: "worker pool size must be at least 1"})
+	}
+
+	name := queueName(r)
+	if _, err := s.queue.WorkerPoolSize(name); err != nil {
+		return writeJson(w, http.StatusNotFound, errorResponse{Error: err.Error()})
+	}
+
+	if err := s.queue.ResizeWorkerPool(name, req.Size); err != nil {
+		slog.Error(fmt.Sprintf("failed to resize worker pool of queue %s: %v", name, err))
+		return writeJson(w, http.StatusConflict, errorResponse{Error: err.Error()})
+	}
+
+	size, _ := s.queue.WorkerPoolSize(name)
+	return writeJson(w, http.StatusOK, WorkerPoolResponse{Queue: name, Size: size})
+}
+
+func (s *server) handleListQueues(w http.ResponseWriter, r *http.Request) error {
+	if s.queue == nil {
+		return writeJson(w, http.StatusServiceUnavailable, errorResponse{Error: "queue is not available"})
+	}
+
+	return writeJson(w, http.StatusOK, QueuesResponse{Queues: s.queue.Queues(), PausedTaskTypes: s.queue.PausedTaskTypes()})
+}
+
+func (s *server) handleCreateQueue(w http.ResponseWriter, r *http.Request) error {
+	if s.queue == nil {
+		return writeJson(w, http.StatusServiceUnavailable, errorResponse{Error: "queue is not available"})
+	}
+
+	req := storage.QueueConfig{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return writeJson(w, http.StatusBadRequest, errorResponse{Error: "failed to decode request body"})
+	}
+
+	if req.WorkerPoolSize < 0 || req.MaxBufferSize < 0 || req.PriorityLevels < 0 {
+		return writeJson(w, http.StatusBadRequest, errorResponse{Error: "queue sizes can't be negative"})
+	}
+
+	info, err := s.queue.CreateQueue(req)
+	if errors.Is(err, queue.ErrInvalidQueue) {
+		return writeJson(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("failed to create queue: %v", err)})
+	}
+	if err != nil {
+		slog.Error(fmt.Sprintf("failed to create queue %s: %v", req.Name, err))
+		return writeJson(w, http.StatusInternalServerError, errorResponse{Error: "failed to create queue"})
+	}
+
+	return writeJson(w, http.StatusCreated, info)
+}
+
+func (s *server) handlePauseQueue(w http.ResponseWriter, r *http.Request) error {
+	return s.setQueuePaused(w, r, true)
+}
+
+func (s *server) handleResumeQueue(w http.ResponseWriter, r *http.Request) error {
+	return s.setQueuePaused(w, r, false)
+}
+
+// setQueuePaused pauses or resumes the queue of the request path, the tasks of a paused queue wait until it is resumed
+func (s *server) setQueuePaused(w http.ResponseWriter, r *http.Request, paused bool) error {
+	if s.queue == nil {
+		return writeJson(w, http.StatusServiceUnavailable, errorResponse{Error: "queue is not available"})
+	}
+
+	name := queueName(r)
+
+	var err error
+	if paused {
+		err = s.queue.PauseQueue(name)
+	} else {
+		err = s.queue.ResumeQueue(name)
+	}
+
+	if errors.Is(err, queue.ErrUnknownQueue) {
+		return writeJson(w, http.StatusNotFound, errorResponse{Error: err.Error()})
+	}
+	if err != nil {
+		slog.Error(fmt.Sprintf("failed to set queue %s paused to %t: %v", name, paused, err))
+		return writeJson(w, http.StatusInternalServerError, errorResponse{Error: "failed to update queue"})
+	}
+
+	return writeJson(w, http.StatusOK, PauseResponse{Queue: name, Paused: paused})
+}
+
+func (s *server) handlePauseTaskType(w http.ResponseWriter, r *http.Request) error {
+	return s.setTaskTypePaused(w, r, true)
+}
+
+func (s *server) handleResumeTaskType(w http.ResponseWriter, r *http.Request) error {
+	return s.setTaskTypePaused(w, r, false)
+}
+
+// setTaskTypePaused pauses or resumes the task type of the request path in every queue
+func (s *server) setTaskTypePaused(w http.ResponseWriter, r *http.Request, paused bool) error {
+	if s.queue == nil {
+		return writeJson(w, http.StatusServiceUnavailable, errorResponse{Error: "queue is not available"})
+	}
+
+	typeOf := task.TypeOf(mux.Vars(r)["taskType"])
+	if !slices.Contains(task.RegisteredTypes(), typeOf) {
+		return writeJson(w, http.StatusBadRequest, errorResponse{TaskType: typeOf, Error: "unsupported task type"})
+	}
+
+	var err error
+	if paused {
+		err = s.queue.PauseTaskType(typeOf)
+	} else {
+		err = s.queue.ResumeTaskType(typeOf)
+	}
+
+	if err != nil {
+		slog.Error(fmt.Sprintf("failed to set task type %s paused to %t: %v", typeOf, paused, err))
+		return writeJson(w, http.StatusInternalServerError, errorResponse{Error: "failed to update task type"})
+	}
+
+	return writeJson(w, http.StatusOK, PauseResponse{TaskType: typeOf, Paused: paused})
+}
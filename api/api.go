@@ -1,15 +1,21 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"expvar"
 	"fmt"
 	"github.com/sinderpl/AsyncTaskProcessor/storage"
 	"log"
 	"log/slog"
 	"net/http"
+	"sync/atomic"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"github.com/sinderpl/AsyncTaskProcessor/queue"
 	"github.com/sinderpl/AsyncTaskProcessor/task"
 )
 
@@ -21,28 +27,72 @@ type server struct {
 	listenAddr string
 	taskChan   *chan []*task.Task
 	db         storage.Storage
+	queue      QueueManager
+
+	idempotencyWindow time.Duration // how long an idempotency key replays its response
+	dedupeWindow      time.Duration // how long a task dedupe key returns the original task
+
+	httpServer *http.Server
+	draining   atomic.Bool // set on shutdown, new tasks are rejected while draining
+}
+
+// QueueManager is implemented by the queue manager so the api can act on the tasks its queues hold
+type QueueManager interface {
+	CancelTask(id string) (bool, error)
+	PendingUniqueTask(taskType task.TypeOf, uniqueKey string) (string, bool)
+	RouteTask(taskType task.TypeOf, queue string) (string, error)
+	ResizeWorkerPool(queue string, size int) error
+	WorkerPoolSize(queue string) (int, error)
+	Queues() []queue.QueueInfo
+	CreateQueue(cfg storage.QueueConfig) (*queue.QueueInfo, error)
+	PauseQueue(name string) error
+	ResumeQueue(name string) error
+	PauseTaskType(typeOf task.TypeOf) error
+	ResumeTaskType(typeOf task.TypeOf) error
+	PausedTaskTypes() []task.TypeOf
 }
 
 type EnqueueTaskPayload struct {
-	Tasks []struct {
-		TaskType        task.TypeOf            `json:"taskType"`
-		Priority        task.ExecutionPriority `json:"priority,omitempty"`
-		BackOffDuration string                 `json:"backOffDuration, omitempty"`
-		Payload         json.RawMessage        `json:"payload,omitempty"`
-	} `json:"Tasks"`
+	Tasks []EnqueueTask `json:"Tasks"`
+}
+
+type EnqueueTask struct {
+	TaskType        task.TypeOf            `json:"taskType"`
+	Priority        task.ExecutionPriority `json:"priority,omitempty"`
+	BackOffDuration string                 `json:"backOffDuration,omitempty"`
+	BackOffPolicy   *task.BackoffPolicy    `json:"backOffPolicy,omitempty"`
+	Timeout         string                 `json:"timeout,omitempty"`
+	MaxRetries      *int                   `json:"maxRetries,omitempty"`
+	RunAt           *time.Time             `json:"runAt,omitempty"` // RFC3339, the task is not processed before it
+	Delay           string                 `json:"delay,omitempty"` // processes the task after the delay, exclusive with runAt
+	Payload         json.RawMessage        `json:"payload,omitempty"`
+	Key             string                 `json:"key,omitempty"`         // names the task within the batch so others can depend on it
+	DependsOn       []string               `json:"dependsOn,omitempty"`   // keys of the tasks which have to succeed first
+	DedupeKey       string                 `json:"dedupeKey,omitempty"`   // submitting the same key again within the window returns the original task
+	OnDuplicate     string                 `json:"onDuplicate,omitempty"` // coalesce (default) or reject when the same work is already pending
+	Queue           string                 `json:"queue,omitempty"`       // named queue to process the task, routed by its type when empty
 }
 
 type EnqueueTaskResponse struct {
-	Tasks  []TaskResponse `json:"tasks"`
-	Status string         `json:"status"`
+	Tasks      []TaskResponse `json:"tasks"`
+	WorkflowId string         `json:"workflowId,omitempty"` // set when the tasks depend on each other
+	Status     string         `json:"status"`
 }
 
 type TaskResponse struct {
-	Id       string                 `json:"id"`
-	TaskType task.TypeOf            `json:"taskType"`
-	Priority task.ExecutionPriority `json:"priority"`
-	Status   task.CurrentStatus     `json:"status"`
-	Err      string                 `json:"err,omitempty"`
+	Id        string                 `json:"id"`
+	TaskType  task.TypeOf            `json:"taskType"`
+	Priority  task.ExecutionPriority `json:"priority"`
+	Status    task.CurrentStatus     `json:"status"`
+	Key       string                 `json:"key,omitempty"`
+	Queue     string                 `json:"queue,omitempty"`
+	Duplicate bool                   `json:"duplicate,omitempty"` // the task was submitted before, the original is returned
+	Err       string                 `json:"err,omitempty"`
+}
+
+type TaskAttemptsResponse struct {
+	Id       string         `json:"id"`
+	Attempts []task.Attempt `json:"attempts"`
 }
 
 type errorResponse struct {
@@ -55,7 +105,12 @@ type option func(server *server)
 
 // CreateApiServer creates and returns the server with predefined options
 func CreateApiServer(opts ...option) *server {
-	srv := server{listenAddr: ""}
+	srv := server{
+		listenAddr:        "",
+		httpServer:        &http.Server{},
+		idempotencyWindow: defaultIdempotencyWindow,
+		dedupeWindow:      defaultIdempotencyWindow,
+	}
 
 	for _, opt := range opts {
 		opt(&srv)
@@ -85,7 +140,32 @@ func WithStorage(storage storage.Storage) option {
 	}
 }
 
-// Run starts the serve and listens on the specified port
+// WithQueueManager lets the api act on the tasks held by the queue such as cancelling them
+func WithQueueManager(queue QueueManager) option {
+	return func(srv *server) {
+		srv.queue = queue
+	}
+}
+
+// WithIdempotencyWindow how long a repeated request with the same Idempotency-Key replays the original response
+func WithIdempotencyWindow(window time.Duration) option {
+	return func(srv *server) {
+		if window > 0 {
+			srv.idempotencyWindow = window
+		}
+	}
+}
+
+// WithDedupeWindow how long a task with the same dedupeKey returns the original task instead of creating a new one
+func WithDedupeWindow(window time.Duration) option {
+	return func(srv *server) {
+		if window > 0 {
+			srv.dedupeWindow = window
+		}
+	}
+}
+
+// Run starts the serve and listens on the specified port, it blocks until the server is shut down
 func (s *server) Run() error {
 	router := mux.NewRouter()
 
@@ -97,79 +177,373 @@ func (s *server) Run() error {
 		HandleFunc("/tasks/enqueue", makeHTTPHandleFunc(s.handleTaskEnqueue)).
 		Methods(http.MethodPost)
 
+	router.
+		HandleFunc("/tasks", makeHTTPHandleFunc(s.handleListTasks)).
+		Methods(http.MethodGet)
+
 	router.
 		HandleFunc("/task/{id}/retry", makeHTTPHandleFunc(s.handleTaskRetry)).
 		Methods(http.MethodPost)
 
+	router.
+		HandleFunc("/task/{id}/cancel", makeHTTPHandleFunc(s.handleTaskCancel)).
+		Methods(http.MethodPost)
+
+	router.
+		HandleFunc("/task/{id}/attempts", makeHTTPHandleFunc(s.handleGetTaskAttempts)).
+		Methods(http.MethodGet)
+
 	router.
 		HandleFunc("/task/{id}", makeHTTPHandleFunc(s.handleGetTaskInfo)).
 		Methods(http.MethodGet)
 
+	router.
+		HandleFunc("/dlq", makeHTTPHandleFunc(s.handleListDeadLetters)).
+		Methods(http.MethodGet)
+
+	router.
+		HandleFunc("/dlq", makeHTTPHandleFunc(s.handlePurgeDeadLetters)).
+		Methods(http.MethodDelete)
+
+	router.
+		HandleFunc("/dlq/requeue", makeHTTPHandleFunc(s.handleRequeueDeadLetters)).
+		Methods(http.MethodPost)
+
+	router.
+		HandleFunc("/dlq/{id}/requeue", makeHTTPHandleFunc(s.handleRequeueDeadLetter)).
+		Methods(http.MethodPost)
+
+	router.
+		HandleFunc("/dlq/{id}", makeHTTPHandleFunc(s.handlePurgeDeadLetters)).
+		Methods(http.MethodDelete)
+
+	router.
+		HandleFunc("/workflows/{id}", makeHTTPHandleFunc(s.handleGetWorkflow)).
+		Methods(http.MethodGet)
+
+	router.
+		HandleFunc("/schedules", makeHTTPHandleFunc(s.handleCreateSchedule)).
+		Methods(http.MethodPost)
+
+	router.
+		HandleFunc("/schedules", makeHTTPHandleFunc(s.handleListSchedules)).
+		Methods(http.MethodGet)
+
+	router.
+		HandleFunc("/schedules/{id}", makeHTTPHandleFunc(s.handleGetSchedule)).
+		Methods(http.MethodGet)
+
+	router.
+		HandleFunc("/schedules/{id}/pause", makeHTTPHandleFunc(s.handlePauseSchedule)).
+		Methods(http.MethodPost)
+
+	router.
+		HandleFunc("/schedules/{id}/resume", makeHTTPHandleFunc(s.handleResumeSchedule)).
+		Methods(http.MethodPost)
+
+	router.
+		HandleFunc("/schedules/{id}", makeHTTPHandleFunc(s.handleDeleteSchedule)).
+		Methods(http.MethodDelete)
+
+	router.
+		HandleFunc("/admin/workers", makeHTTPHandleFunc(s.handleGetWorkerPool)).
+		Methods(http.MethodGet)
+
+	router.
+		HandleFunc("/admin/workers", makeHTTPHandleFunc(s.handleResizeWorkerPool)).
+		Methods(http.MethodPut)
+
+	router.
+		HandleFunc("/admin/queues", makeHTTPHandleFunc(s.handleListQueues)).
+		Methods(http.MethodGet)
+
+	router.
+		HandleFunc("/admin/queues", makeHTTPHandleFunc(s.handleCreateQueue)).
+		Methods(http.MethodPost)
+
+	router.
+		HandleFunc("/admin/queues/{name}/workers", makeHTTPHandleFunc(s.handleGetWorkerPool)).
+		Methods(http.MethodGet)
+
+	router.
+		HandleFunc("/admin/queues/{name}/workers", makeHTTPHandleFunc(s.handleResizeWorkerPool)).
+		Methods(http.MethodPut)
+
+	router.
+		HandleFunc("/admin/queues/{name}/pause", makeHTTPHandleFunc(s.handlePauseQueue)).
+		Methods(http.MethodPost)
+
+	router.
+		HandleFunc("/admin/queues/{name}/resume", makeHTTPHandleFunc(s.handleResumeQueue)).
+		Methods(http.MethodPost)
+
+	router.
+		HandleFunc("/admin/taskTypes/{taskType}/pause", makeHTTPHandleFunc(s.handlePauseTaskType)).
+		Methods(http.MethodPost)
+
+	router.
+		HandleFunc("/admin/taskTypes/{taskType}/resume", makeHTTPHandleFunc(s.handleResumeTaskType)).
+		Methods(http.MethodPost)
+
+	// Exposes the runtime metrics such as the autoscaler decisions
+	router.Handle("/debug/vars", expvar.Handler()).
+		Methods(http.MethodGet)
+
+	s.httpServer.Addr = s.listenAddr
+	s.httpServer.Handler = router
+
 	slog.Info("server ready  and listening for requests")
-	http.ListenAndServe(s.listenAddr, router)
+	if err := s.httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
 
 	return nil
 }
 
+// Shutdown stops accepting new tasks and waits for the requests in flight to finish until the context is done
+func (s *server) Shutdown(ctx context.Context) error {
+	s.draining.Store(true)
+
+	slog.Info("server shutting down, no longer accepting requests")
+	return s.httpServer.Shutdown(ctx)
+}
+
 func (s *server) handleHealthz(w http.ResponseWriter, r *http.Request) error {
+	if s.draining.Load() {
+		return writeJson(w, http.StatusServiceUnavailable, "service is shutting down")
+	}
 	return writeJson(w, http.StatusOK, "service is healthy")
 }
 
 func (s *server) handleTaskEnqueue(w http.ResponseWriter, r *http.Request) error {
+	if s.draining.Load() {
+		return writeJson(w, http.StatusServiceUnavailable, errorResponse{Error: "service is shutting down"})
+	}
 
 	req := new(EnqueueTaskPayload)
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		slog.Error("failed to decode request body", err)
+		slog.Error(fmt.Sprintf("failed to decode request body: %v", err))
 		return errors.New("failed to decode request body")
 	}
 
+	if key := r.Header.Get(idempotencyKeyHeader); key != "" {
+		return s.idempotent(w, testUserId, key, func() (int, any) { // TODO add user session validation
+			return s.enqueueTasks(req)
+		})
+	}
+
+	status, resp := s.enqueueTasks(req)
+
+	return writeJson(w, status, resp)
+}
+
+// enqueueTasks creates, persists and enqueues the tasks of the request, returning the response and its status
+func (s *server) enqueueTasks(req *EnqueueTaskPayload) (int, any) {
 	resp := EnqueueTaskResponse{
-		Tasks: make([]TaskResponse, 0, len(req.Tasks)),
+		Tasks: make([]TaskResponse, len(req.Tasks)),
 	}
 
-	newTasks := make([]*task.Task, 0, len(req.Tasks))
+	// Tasks are created with their dependencies first so the ids of the dependencies are known
+	order, err := planWorkflow(req.Tasks)
+	if err != nil {
+		return http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("invalid workflow: %v", err)}
+	}
 
+	workflowId := ""
 	for _, t := range req.Tasks {
-		var tResp TaskResponse
+		if t.Key != "" || len(t.DependsOn) > 0 {
+			workflowId = uuid.New().String()
+			break
+		}
+	}
+
+	newTasks := make([]*task.Task, 0, len(req.Tasks))
+	indexes := make([]int, 0, len(req.Tasks))              // request index of every new task
+	policies := make([]DuplicatePolicy, 0, len(req.Tasks)) // what happens to every new task when its work is pending
+	keyIds := make(map[string]string)
+
+	for _, i := range order {
+		t := req.Tasks[i]
+
+		dependsOn := make([]string, 0, len(t.DependsOn))
+		for _, key := range t.DependsOn {
+			dependsOn = append(dependsOn, keyIds[key])
+		}
+
+		runAt, err := resolveRunAt(t.RunAt, t.Delay)
+		if err == nil && t.DedupeKey != "" && workflowId != "" {
+			err = errors.New("dedupeKey can't be set on tasks of a workflow")
+		}
+		var policy DuplicatePolicy
+		if err == nil {
+			policy, err = parseDuplicatePolicy(t.OnDuplicate)
+		}
+		routed := t.Queue
+		if err == nil && s.queue != nil {
+			routed, err = s.queue.RouteTask(t.TaskType, t.Queue)
+		}
+		if err != nil {
+			return http.StatusBadRequest, errorResponse{
+				Priority: t.Priority,
+				TaskType: t.TaskType,
+				Error:    fmt.Sprintf("failed to create task: %v", err),
+			}
+		}
 
 		newTask, err := task.CreateTask(
 			task.WithType(t.TaskType),
 			task.WithBackoffTime(t.BackOffDuration),
+			task.WithBackoffPolicy(t.BackOffPolicy),
+			task.WithTimeout(t.Timeout),
+			task.WithMaxRetries(t.MaxRetries),
+			task.WithRunAt(runAt),
+			task.WithWorkflow(workflowId, t.Key),
+			task.WithDependsOn(dependsOn),
+			task.WithDedupeKey(t.DedupeKey),
+			task.WithQueue(routed),
 			task.WithCreatedBy(testUserId), // TODO add user session validation
 			task.WithPriority(t.Priority),
 			task.WithPayload(t.Payload))
 
 		if newTask == nil || err != nil {
-			resp := errorResponse{
+			return http.StatusBadRequest, errorResponse{
 				Priority: t.Priority,
 				TaskType: t.TaskType,
 				Error:    fmt.Sprintf("failed to create task: %v", err),
 			}
-			return writeJson(w, http.StatusBadRequest, resp)
 		}
 
-		tResp = TaskResponse{
+		if t.Key != "" {
+			keyIds[t.Key] = newTask.Id
+		}
+
+		newTasks = append(newTasks, newTask)
+		indexes = append(indexes, i)
+		policies = append(policies, policy)
+		resp.Tasks[i] = TaskResponse{
 			Id:       newTask.Id,
 			TaskType: newTask.TaskType,
 			Priority: newTask.Priority,
 			Status:   newTask.Status,
+			Key:      newTask.Key,
+			Queue:    newTask.Queue,
+		}
+	}
+	resp.WorkflowId = workflowId
+
+	// Tasks whose work is already pending are coalesced into the pending task or reject the request before
+	// anything is persisted, a workflow can't depend on a task outside of it so its tasks are always rejected
+	coalesced := make(map[int]bool)
+	unique := make(map[string]*task.Task) // tasks of the request by type and unique key
+	for n, t := range newTasks {
+		if t.UniqueKey == "" {
+			continue
 		}
 
-		newTasks = append(newTasks, newTask)
-		resp.Tasks = append(resp.Tasks, tResp)
+		pending, err := s.pendingUniqueTask(t)
+		if err != nil {
+			slog.Error(fmt.Sprintf("failed to check pending tasks with unique key %s: %v", t.UniqueKey, err))
+			return http.StatusInternalServerError, errorResponse{Error: "failed to check pending tasks"}
+		}
+
+		key := fmt.Sprintf("%s:%s", t.TaskType, t.UniqueKey)
+		if pending == nil {
+			if pending = unique[key]; pending == nil {
+				unique[key] = t
+				continue
+			}
+		}
+
+		if policies[n] == DuplicateReject || workflowId != "" {
+			return uniqueConflict(t, pending.Id)
+		}
+
+		coalesced[n] = true
+		resp.Tasks[indexes[n]] = duplicateResponse(pending)
 	}
 
-	// Write tasks to queue so it can distribute and begin processing
-	*s.taskChan <- newTasks
+	// Persist the tasks before handing them over to the queue so they can be recovered if the process stops
+	enqueued := make([]*task.Task, 0, len(newTasks))
+	for n, t := range newTasks {
+		if coalesced[n] {
+			continue
+		}
+
+		id, err := s.persistTask(t)
+		if errors.Is(err, storage.ErrUniqueTaskPending) {
+			// The same work was enqueued since it was checked
+			pending, err := s.db.GetPendingUniqueTask(t.TaskType, t.UniqueKey)
+			if err != nil || pending == nil {
+				slog.Error(fmt.Sprintf("failed to load pending task with unique key %s: %v", t.UniqueKey, err))
+				return http.StatusInternalServerError, errorResponse{Error: "failed to persist tasks"}
+			}
 
-	for _, task := range newTasks {
-		s.db.CreateTask(task)
+			if policies[n] == DuplicateReject || workflowId != "" {
+				return uniqueConflict(t, pending.Id)
+			}
+
+			resp.Tasks[indexes[n]] = duplicateResponse(pending)
+			continue
+		}
+
+		if err != nil {
+			slog.Error(fmt.Sprintf("failed to persist task: %v", err))
+			return http.StatusInternalServerError, errorResponse{Error: "failed to persist tasks"}
+		}
+
+		if id == t.Id {
+			enqueued = append(enqueued, t)
+			continue
+		}
+
+		// The same task was submitted within the dedupe window, the original task is returned instead
+		original, err := s.db.GetTaskById(id)
+		if err != nil {
+			return http.StatusInternalServerError, errorResponse{Error: "failed to load deduplicated task"}
+		}
+
+		resp.Tasks[indexes[n]] = duplicateResponse(original)
+	}
+
+	// Write tasks to queue so it can distribute and begin processing
+	if len(enqueued) > 0 {
+		*s.taskChan <- enqueued
 	}
 
 	resp.Status = "Successfully enqueued valid tasks"
 
-	return writeJson(w, http.StatusOK, resp)
+	return http.StatusOK, resp
+}
+
+// persistTask creates the task, a task with a dedupe key is only created when the key was not used within the
+// dedupe window. Returns the id of the task holding the dedupe key, the id of the task itself when it was created
+func (s *server) persistTask(t *task.Task) (string, error) {
+	if t.DedupeKey == "" {
+		return t.Id, s.db.CreateTask(t)
+	}
+
+	return s.db.CreateDedupedTask(t, s.dedupeWindow)
+}
+
+// resolveRunAt returns when the task should first run from either the runAt timestamp or the delay, nil runs it right away
+func resolveRunAt(runAt *time.Time, delay string) (*time.Time, error) {
+	if delay == "" {
+		return runAt, nil
+	}
+
+	if runAt != nil {
+		return nil, errors.New("only one of runAt and delay can be set")
+	}
+
+	d, err := time.ParseDuration(delay)
+	if err != nil || d < 0 {
+		return nil, fmt.Errorf("invalid delay: %s", delay)
+	}
+
+	at := time.Now().UTC().Add(d)
+
+	return &at, nil
 }
 
 func (s *server) handleGetTaskInfo(w http.ResponseWriter, r *http.Request) error {
@@ -186,18 +560,34 @@ func (s *server) handleGetTaskInfo(w http.ResponseWriter, r *http.Request) error
 		return writeJson(w, http.StatusNotFound, fmt.Errorf("task not found"))
 	}
 
-	tResp := TaskResponse{
-		Id:       task.Id,
-		TaskType: task.TaskType,
-		Priority: task.Priority,
-		Status:   task.Status,
-		Err:      task.ErrorDetails,
+	return writeJson(w, http.StatusOK, newTaskInfoResponse(task))
+}
+
+func (s *server) handleGetTaskAttempts(w http.ResponseWriter, r *http.Request) error {
+	idStr, ok := mux.Vars(r)["id"]
+
+	if !ok {
+		return fmt.Errorf("id required to find task")
 	}
 
-	return writeJson(w, http.StatusOK, tResp)
+	if t, err := s.db.GetTaskById(idStr); err != nil || t == nil {
+		return writeJson(w, http.StatusNotFound, errorResponse{Error: "task not found"})
+	}
+
+	attempts, err := s.db.GetTaskAttempts(idStr)
+	if err != nil {
+		slog.Error(fmt.Sprintf("failed to load attempts of task %s: %v", idStr, err))
+		return writeJson(w, http.StatusInternalServerError, errorResponse{Error: "failed to load task attempts"})
+	}
+
+	return writeJson(w, http.StatusOK, TaskAttemptsResponse{Id: idStr, Attempts: attempts})
 }
 
 func (s *server) handleTaskRetry(w http.ResponseWriter, r *http.Request) error {
+	if s.draining.Load() {
+		return writeJson(w, http.StatusServiceUnavailable, errorResponse{Error: "service is shutting down"})
+	}
+
 	idStr, ok := mux.Vars(r)["id"]
 
 	if !ok {
@@ -211,37 +601,146 @@ func (s *server) handleTaskRetry(w http.ResponseWriter, r *http.Request) error {
 		return writeJson(w, http.StatusNotFound, fmt.Errorf("task not found"))
 	}
 
-	if t.Status != task.ProcessingFailed {
-		return fmt.Errorf("only failed tasks can be retired, task status :%s", t.Status)
+	if err := s.requeueTask(t, nil); err != nil {
+		if errors.Is(err, storage.ErrTaskStatusChanged) {
+			return writeJson(w, http.StatusConflict, errorResponse{Error: err.Error()})
+		}
+		return err
 	}
 
-	processable, err := t.ParseTaskType()
+	tResp := TaskResponse{
+		Id:       t.Id,
+		TaskType: t.TaskType,
+		Priority: t.Priority,
+		Status:   t.Status,
+	}
 
-	if err != nil {
-		return err
+	return writeJson(w, http.StatusOK, tResp)
+}
+
+func (s *server) handleTaskCancel(w http.ResponseWriter, r *http.Request) error {
+	idStr, ok := mux.Vars(r)["id"]
+	if !ok {
+		return fmt.Errorf("id required to find task")
 	}
 
-	// TODO loading and retrying the task could be structured better
-	t.ProcessableTask = processable
-	t.Error = nil
-	t.ErrorDetails = ""
-	t.Status = task.ProcessingAwaiting
+	t, err := s.db.GetTaskById(idStr)
+	if err != nil || t == nil {
+		return writeJson(w, http.StatusNotFound, errorResponse{Error: "task not found"})
+	}
 
-	// Write tasks to queue so it can distribute and begin processing
-	*s.taskChan <- []*task.Task{t}
+	if t.Status.IsFinal() {
+		return writeJson(w, http.StatusConflict, errorResponse{Error: fmt.Sprintf("task can't be cancelled, task status: %s", t.Status)})
+	}
+
+	cancelled := false
+	if s.queue != nil {
+		if cancelled, err = s.queue.CancelTask(t.Id); err != nil {
+			slog.Error(fmt.Sprintf("failed to cancel task %s: %v", t.Id, err))
+			return writeJson(w, http.StatusInternalServerError, errorResponse{Error: "failed to cancel task"})
+		}
+	}
+
+	// The queue does not hold the task, it was not received yet or is not recovered until a restart. The saved
+	// status keeps the queue from dispatching it, a task received before the status was saved is cancelled in
+	// the queue once more
+	if !cancelled {
+		if err := s.db.UpdateTaskStatus(t.Id, task.ProcessingCancelled); err != nil {
+			slog.Error(fmt.Sprintf("failed to cancel task %s: %v", t.Id, err))
+			return writeJson(w, http.StatusInternalServerError, errorResponse{Error: "failed to cancel task"})
+		}
 
-	s.db.UpdateTask(t)
+		if s.queue != nil {
+			if _, err := s.queue.CancelTask(t.Id); err != nil {
+				slog.Error(fmt.Sprintf("failed to cancel task %s: %v", t.Id, err))
+				return writeJson(w, http.StatusInternalServerError, errorResponse{Error: "failed to cancel task"})
+			}
+		}
+	}
 
 	tResp := TaskResponse{
 		Id:       t.Id,
 		TaskType: t.TaskType,
 		Priority: t.Priority,
-		Status:   t.Status,
+		Status:   task.ProcessingCancelled,
 	}
 
 	return writeJson(w, http.StatusOK, tResp)
 }
 
+// requeueTask resets a failed task, removes it from the dead letter queue and hands it back to the queue.
+// A non empty payload replaces the original one and is validated like a new task
+func (s *server) requeueTask(t *task.Task, payload json.RawMessage) error {
+	if t.Status != task.ProcessingFailed {
+		return fmt.Errorf("only failed tasks can be retired, task status :%s", t.Status)
+	}
+
+	attempts, err := s.db.GetTaskAttempts(t.Id)
+	if err != nil {
+		return fmt.Errorf("failed to load task attempts: %v", err)
+	}
+	t.Attempts = attempts
+
+	if len(payload) > 0 {
+		t.Payload = payload
+
+		processable, err := t.ParseTaskType()
+		if err != nil {
+			return fmt.Errorf("task parsing failed: %v", err)
+		}
+
+		if err := processable.ValidateTask(); err != nil {
+			return fmt.Errorf("task payload validation failed: %v", err)
+		}
+
+		t.ProcessableTask = processable
+		t.UniqueKey = task.UniqueKeyOf(processable)
+	}
+
+	// The storage layer rehydrates the task through the task registry, a missing implementation means
+	// the task type is no longer registered
+	if t.ProcessableTask == nil {
+		return fmt.Errorf("unsupported task type: %s", t.TaskType)
+	}
+
+	// A requeued task starts over with a fresh set of retries
+	t.Error = nil
+	t.ErrorDetails = ""
+	t.Status = task.ProcessingAwaiting
+	t.Retries = 0
+	t.BackOffUntil = nil
+	t.LastBackOff = 0
+	t.FinishedAt = nil
+
+	// The same work may have been enqueued again since the task failed
+	if t.UniqueKey != "" {
+		pending, err := s.pendingUniqueTask(t)
+		if err != nil {
+			return fmt.Errorf("failed to check pending tasks: %v", err)
+		}
+		if pending != nil {
+			return fmt.Errorf("task %s with the same unique key is already pending", pending.Id)
+		}
+	}
+
+	// Only one of concurrent requeues of the task gets to move it out of the failed status
+	if err := s.db.UpdateTaskFrom(t, task.ProcessingFailed); err != nil {
+		if errors.Is(err, storage.ErrTaskStatusChanged) {
+			return fmt.Errorf("task is no longer failed, it was requeued concurrently: %w", err)
+		}
+		return fmt.Errorf("failed to update task: %v", err)
+	}
+
+	if _, err := s.db.DeleteDeadLetters(storage.DeadLetterFilter{TaskIds: []string{t.Id}}); err != nil {
+		slog.Error(fmt.Sprintf("failed to remove task %s from the dead letter queue: %v", t.Id, err))
+	}
+
+	// Write tasks to queue so it can distribute and begin processing
+	*s.taskChan <- []*task.Task{t}
+
+	return nil
+}
+
 func makeHTTPHandleFunc(f func(http.ResponseWriter, *http.Request) error) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if err := f(w, r); err != nil {
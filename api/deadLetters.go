@@ -0,0 +1,200 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/sinderpl/AsyncTaskProcessor/storage"
+	"github.com/sinderpl/AsyncTaskProcessor/task"
+)
+
+// Package api/deadLetters deals with inspecting, requeueing and purging the dead letter queue
+
+type RequeueDeadLettersPayload struct {
+	Tasks []RequeueDeadLetterPayload `json:"tasks"`
+}
+
+type RequeueDeadLetterPayload struct {
+	Id      string          `json:"id,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"` // replaces the original payload when set
+}
+
+type DeadLettersResponse struct {
+	DeadLetters []*storage.DeadLetter `json:"deadLetters"`
+}
+
+type RequeueDeadLettersResponse struct {
+	Tasks  []TaskResponse `json:"tasks"`
+	Status string         `json:"status"`
+}
+
+type PurgeDeadLettersResponse struct {
+	Purged int64 `json:"purged"`
+}
+
+func (s *server) handleListDeadLetters(w http.ResponseWriter, r *http.Request) error {
+	filter, err := parseDeadLetterFilter(r)
+	if err != nil {
+		return err
+	}
+
+	deadLetters, err := s.db.ListDeadLetters(filter)
+	if err != nil {
+		slog.Error(fmt.Sprintf("failed to list dead letters: %v", err))
+		return writeJson(w, http.StatusInternalServerError, errorResponse{Error: "failed to list dead letters"})
+	}
+
+	return writeJson(w, http.StatusOK, DeadLettersResponse{DeadLetters: deadLetters})
+}
+
+func (s *server) handleRequeueDeadLetter(w http.ResponseWriter, r *http.Request) error {
+	if s.draining.Load() {
+		return writeJson(w, http.StatusServiceUnavailable, errorResponse{Error: "service is shutting down"})
+	}
+
+	idStr, ok := mux.Vars(r)["id"]
+	if !ok {
+		return fmt.Errorf("id required to find task")
+	}
+
+	// The body is optional, it is only needed to edit the payload
+	req := RequeueDeadLetterPayload{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		return errors.New("failed to decode request body")
+	}
+	req.Id = idStr
+
+	resp, err := s.requeueDeadLetters([]RequeueDeadLetterPayload{req})
+	if err != nil {
+		return err
+	}
+
+	return writeJson(w, http.StatusOK, resp)
+}
+
+func (s *server) handleRequeueDeadLetters(w http.ResponseWriter, r *http.Request) error {
+	if s.draining.Load() {
+		return writeJson(w, http.StatusServiceUnavailable, errorResponse{Error: "service is shutting down"})
+	}
+
+	req := RequeueDeadLettersPayload{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return errors.New("failed to decode request body")
+	}
+
+	if len(req.Tasks) == 0 {
+		return errors.New("at least one task must be requeued")
+	}
+
+	resp, err := s.requeueDeadLetters(req.Tasks)
+	if err != nil {
+		return err
+	}
+
+	return writeJson(w, http.StatusOK, resp)
+}
+
+// requeueDeadLetters requeues every task in the dead letter queue, a task which can't be requeued is reported
+// in the response without stopping the others
+func (s *server) requeueDeadLetters(reqs []RequeueDeadLetterPayload) (RequeueDeadLettersResponse, error) {
+	ids := make([]string, 0, len(reqs))
+	for _, req := range reqs {
+		if req.Id == "" {
+			return RequeueDeadLettersResponse{}, errors.New("task id must be set")
+		}
+		ids = append(ids, req.Id)
+	}
+
+	deadLetters, err := s.db.ListDeadLetters(storage.DeadLetterFilter{TaskIds: ids})
+	if err != nil {
+		return RequeueDeadLettersResponse{}, fmt.Errorf("failed to find dead letters: %v", err)
+	}
+
+	inQueue := make(map[string]bool, len(deadLetters))
+	for _, d := range deadLetters {
+		inQueue[d.TaskId] = true
+	}
+
+	resp := RequeueDeadLettersResponse{
+		Tasks: make([]TaskResponse, 0, len(reqs)),
+	}
+
+	for _, req := range reqs {
+		tResp := TaskResponse{Id: req.Id}
+
+		if !inQueue[req.Id] {
+			tResp.Err = "task not found in dead letter queue"
+			resp.Tasks = append(resp.Tasks, tResp)
+			continue
+		}
+
+		t, err := s.db.GetTaskById(req.Id)
+		if err != nil || t == nil {
+			tResp.Err = "task not found"
+			resp.Tasks = append(resp.Tasks, tResp)
+			continue
+		}
+
+		if err := s.requeueTask(t, req.Payload); err != nil {
+			tResp.Err = err.Error()
+		}
+
+		tResp.TaskType = t.TaskType
+		tResp.Priority = t.Priority
+		tResp.Status = t.Status
+		resp.Tasks = append(resp.Tasks, tResp)
+	}
+
+	resp.Status = "Requeued dead letters"
+
+	return resp, nil
+}
+
+func (s *server) handlePurgeDeadLetters(w http.ResponseWriter, r *http.Request) error {
+	filter, err := parseDeadLetterFilter(r)
+	if err != nil {
+		return err
+	}
+
+	if idStr, ok := mux.Vars(r)["id"]; ok {
+		filter.TaskIds = []string{idStr}
+	}
+
+	purged, err := s.db.DeleteDeadLetters(filter)
+	if err != nil {
+		slog.Error(fmt.Sprintf("failed to purge dead letters: %v", err))
+		return writeJson(w, http.StatusInternalServerError, errorResponse{Error: "failed to purge dead letters"})
+	}
+
+	return writeJson(w, http.StatusOK, PurgeDeadLettersResponse{Purged: purged})
+}
+
+// parseDeadLetterFilter reads the dead letter filters from the query parameters
+func parseDeadLetterFilter(r *http.Request) (storage.DeadLetterFilter, error) {
+	query := r.URL.Query()
+
+	filter := storage.DeadLetterFilter{
+		TaskType:  task.TypeOf(query.Get("taskType")),
+		CreatedBy: query.Get("createdBy"),
+	}
+
+	var err error
+	if filter.FailedAfter, err = parseTimeParam(query, "failedAfter"); err != nil {
+		return filter, err
+	}
+
+	if filter.FailedBefore, err = parseTimeParam(query, "failedBefore"); err != nil {
+		return filter, err
+	}
+
+	if filter.Limit, err = parseLimitParam(query); err != nil {
+		return filter, err
+	}
+
+	return filter, nil
+}
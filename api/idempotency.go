@@ -0,0 +1,53 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/sinderpl/AsyncTaskProcessor/storage"
+)
+
+// Package api/idempotency deals with replaying the response of requests repeated with the same idempotency key
+
+const (
+	idempotencyKeyHeader     = "Idempotency-Key"
+	idempotentReplayHeader   = "Idempotent-Replayed"
+	defaultIdempotencyWindow = 24 * time.Hour
+)
+
+// idempotent runs the request once per idempotency key of the user within the window, a repeated request gets the
+// stored response of the first one. A request which did not succeed releases the key so it can be retried
+func (s *server) idempotent(w http.ResponseWriter, createdBy string, key string, handle func() (int, any)) error {
+	stored, claimed, err := s.db.ClaimIdempotencyKey(createdBy, key, s.idempotencyWindow)
+	if err != nil {
+		slog.Error(fmt.Sprintf("failed to claim idempotency key: %v", err))
+		return writeJson(w, http.StatusInternalServerError, errorResponse{Error: "failed to check idempotency key"})
+	}
+
+	if !claimed {
+		if stored == nil {
+			return writeJson(w, http.StatusConflict, errorResponse{Error: "a request with this idempotency key is in progress"})
+		}
+
+		w.Header().Set(idempotentReplayHeader, "true")
+		return writeJson(w, stored.Status, stored.Response)
+	}
+
+	status, resp := handle()
+
+	body, err := json.Marshal(resp)
+	if err == nil && status >= http.StatusOK && status < http.StatusMultipleChoices {
+		err = s.db.SaveIdempotentResponse(createdBy, key, storage.IdempotentResponse{Status: status, Response: body})
+	} else {
+		err = s.db.ReleaseIdempotencyKey(createdBy, key)
+	}
+
+	if err != nil {
+		slog.Error(fmt.Sprintf("failed to store response of idempotency key %s: %v", key, err))
+	}
+
+	return writeJson(w, status, resp)
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sinderpl/AsyncTaskProcessor/schedule"
+	"github.com/sinderpl/AsyncTaskProcessor/task"
+)
+
+// Package api/schedules deals with creating and managing recurring task schedules
+
+type CreateSchedulePayload struct {
+	Cron       string                 `json:"cron"`
+	Timezone   string                 `json:"timezone,omitempty"`
+	TaskType   task.TypeOf            `json:"taskType"`
+	Priority   task.ExecutionPriority `json:"priority,omitempty"`
+	Timeout    string                 `json:"timeout,omitempty"`
+	MaxRetries *int                   `json:"maxRetries,omitempty"`
+	Payload    json.RawMessage        `json:"payload"` // rendered as a text/template on every run
+}
+
+type SchedulesResponse struct {
+	Schedules []*schedule.Schedule `json:"schedules"`
+}
+
+func (s *server) handleCreateSchedule(w http.ResponseWriter, r *http.Request) error {
+	req := CreateSchedulePayload{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return errors.New("failed to decode request body")
+	}
+
+	sched, err := schedule.CreateSchedule(
+		schedule.WithCron(req.Cron),
+		schedule.WithTimezone(req.Timezone),
+		schedule.WithType(req.TaskType),
+		schedule.WithPriority(req.Priority),
+		schedule.WithTimeout(req.Timeout),
+		schedule.WithMaxRetries(req.MaxRetries),
+		schedule.WithCreatedBy(testUserId), // TODO add user session validation
+		schedule.WithPayloadTemplate(string(req.Payload)))
+	if err != nil {
+		return writeJson(w, http.StatusBadRequest, errorResponse{
+			Priority: req.Priority,
+			TaskType: req.TaskType,
+			Error:    fmt.Sprintf("failed to create schedule: %v", err),
+		})
+	}
+
+	if err := s.db.CreateSchedule(sched); err != nil {
+		slog.Error(fmt.Sprintf("failed to persist schedule: %v", err))
+		return writeJson(w, http.StatusInternalServerError, errorResponse{Error: "failed to persist schedule"})
+	}
+
+	return writeJson(w, http.StatusCreated, sched)
+}
+
+func (s *server) handleListSchedules(w http.ResponseWriter, r *http.Request) error {
+	schedules, err := s.db.ListSchedules()
+	if err != nil {
+		slog.Error(fmt.Sprintf("failed to list schedules: %v", err))
+		return writeJson(w, http.StatusInternalServerError, errorResponse{Error: "failed to list schedules"})
+	}
+
+	return writeJson(w, http.StatusOK, SchedulesResponse{Schedules: schedules})
+}
+
+func (s *server) handleGetSchedule(w http.ResponseWriter, r *http.Request) error {
+	sched, err := s.findSchedule(r)
+	if err != nil || sched == nil {
+		return writeJson(w, http.StatusNotFound, errorResponse{Error: "schedule not found"})
+	}
+
+	return writeJson(w, http.StatusOK, sched)
+}
+
+func (s *server) handlePauseSchedule(w http.ResponseWriter, r *http.Request) error {
+	sched, err := s.findSchedule(r)
+	if err != nil || sched == nil {
+		return writeJson(w, http.StatusNotFound, errorResponse{Error: "schedule not found"})
+	}
+
+	sched.Status = schedule.Paused
+
+	return s.saveSchedule(w, sched)
+}
+
+// handleResumeSchedule resumes the schedule from its next run after now, runs missed while paused are skipped
+func (s *server) handleResumeSchedule(w http.ResponseWriter, r *http.Request) error {
+	sched, err := s.findSchedule(r)
+	if err != nil || sched == nil {
+		return writeJson(w, http.StatusNotFound, errorResponse{Error: "schedule not found"})
+	}
+
+	if err := sched.Parse(); err != nil {
+		return err
+	}
+
+	sched.Status = schedule.Active
+	sched.NextRunAt = sched.NextRun(time.Now().UTC())
+
+	return s.saveSchedule(w, sched)
+}
+
+func (s *server) handleDeleteSchedule(w http.ResponseWriter, r *http.Request) error {
+	idStr, ok := mux.Vars(r)["id"]
+	if !ok {
+		return fmt.Errorf("id required to find schedule")
+	}
+
+	deleted, err := s.db.DeleteSchedule(idStr)
+	if err != nil {
+		slog.Error(fmt.Sprintf("failed to delete schedule %s: %v", idStr, err))
+		return writeJson(w, http.StatusInternalServerError, errorResponse{Error: "failed to delete schedule"})
+	}
+
+	if !deleted {
+		return writeJson(w, http.StatusNotFound, errorResponse{Error: "schedule not found"})
+	}
+
+	return writeJson(w, http.StatusOK, map[string]string{"id": idStr, "status": "Deleted"})
+}
+
+// findSchedule loads the schedule of the id in the route
+func (s *server) findSchedule(r *http.Request) (*schedule.Schedule, error) {
+	idStr, ok := mux.Vars(r)["id"]
+	if !ok {
+		return nil, fmt.Errorf("id required to find schedule")
+	}
+
+	return s.db.GetScheduleById(idStr)
+}
+
+func (s *server) saveSchedule(w http.ResponseWriter, sched *schedule.Schedule) error {
+	if err := s.db.UpdateSchedule(sched); err != nil {
+		slog.Error(fmt.Sprintf("failed to update schedule %s: %v", sched.Id, err))
+		return writeJson(w, http.StatusInternalServerError, errorResponse{Error: "failed to update schedule"})
+	}
+
+	return writeJson(w, http.StatusOK, sched)
+}
@@ -0,0 +1,168 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/sinderpl/AsyncTaskProcessor/storage"
+	"github.com/sinderpl/AsyncTaskProcessor/task"
+)
+
+// Package api/tasks deals with listing and searching tasks
+
+type ListTasksResponse struct {
+	Tasks      []TaskInfoResponse `json:"tasks"`
+	NextCursor string             `json:"nextCursor,omitempty"` // empty on the last page
+}
+
+type TaskInfoResponse struct {
+	TaskResponse
+	CreatedBy  string          `json:"createdBy"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	StartedAt  *time.Time      `json:"startedAt,omitempty"`
+	FinishedAt *time.Time      `json:"finishedAt,omitempty"`
+	Retries    int             `json:"retries"`
+	RunAt      *time.Time      `json:"runAt,omitempty"` // set while the task is scheduled
+	WorkflowId string          `json:"workflowId,omitempty"`
+	DependsOn  []string        `json:"dependsOn,omitempty"` // ids of the tasks it depends on
+	Result     json.RawMessage `json:"result,omitempty"`    // set once the task processed successfully
+}
+
+func newTaskInfoResponse(t *task.Task) TaskInfoResponse {
+	resp := TaskInfoResponse{
+		TaskResponse: TaskResponse{
+			Id:       t.Id,
+			TaskType: t.TaskType,
+			Priority: t.Priority,
+			Status:   t.Status,
+			Key:      t.Key,
+			Queue:    t.Queue,
+			Err:      t.ErrorDetails,
+		},
+		CreatedBy:  t.CreatedBy,
+		CreatedAt:  t.CreatedAt,
+		StartedAt:  t.StartedAt,
+		FinishedAt: t.FinishedAt,
+		Retries:    t.Retries,
+		WorkflowId: t.WorkflowId,
+		DependsOn:  t.DependsOn,
+		Result:     t.Result,
+	}
+
+	if t.Status == task.ProcessingScheduled {
+		resp.RunAt = t.BackOffUntil
+	}
+
+	return resp
+}
+
+func (s *server) handleListTasks(w http.ResponseWriter, r *http.Request) error {
+	filter, err := parseTaskFilter(r.URL.Query())
+	if err != nil {
+		return err
+	}
+
+	tasks, next, err := s.db.ListTasks(filter)
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidCursor) {
+			return err
+		}
+		slog.Error(fmt.Sprintf("failed to list tasks: %v", err))
+		return writeJson(w, http.StatusInternalServerError, errorResponse{Error: "failed to list tasks"})
+	}
+
+	resp := ListTasksResponse{
+		Tasks:      make([]TaskInfoResponse, 0, len(tasks)),
+		NextCursor: next,
+	}
+
+	for _, t := range tasks {
+		resp.Tasks = append(resp.Tasks, newTaskInfoResponse(t))
+	}
+
+	return writeJson(w, http.StatusOK, resp)
+}
+
+// parseTaskFilter reads the task filters, sort and pagination from the query parameters,
+// status can be repeated to match any of the statuses
+func parseTaskFilter(query url.Values) (storage.TaskFilter, error) {
+	filter := storage.TaskFilter{
+		TaskType:  task.TypeOf(query.Get("taskType")),
+		CreatedBy: query.Get("createdBy"),
+		Sort:      storage.TaskSort(query.Get("sort")),
+		Cursor:    query.Get("cursor"),
+	}
+
+	for _, status := range query["status"] {
+		filter.Statuses = append(filter.Statuses, task.CurrentStatus(status))
+	}
+
+	if value := query.Get("priority"); value != "" {
+		priority, err := strconv.Atoi(value)
+		if err != nil {
+			return filter, errors.New("priority must be a number")
+		}
+		p := task.ExecutionPriority(priority)
+		filter.Priority = &p
+	}
+
+	var err error
+	if filter.CreatedAfter, err = parseTimeParam(query, "createdAfter"); err != nil {
+		return filter, err
+	}
+
+	if filter.CreatedBefore, err = parseTimeParam(query, "createdBefore"); err != nil {
+		return filter, err
+	}
+
+	if filter.FinishedAfter, err = parseTimeParam(query, "finishedAfter"); err != nil {
+		return filter, err
+	}
+
+	if filter.FinishedBefore, err = parseTimeParam(query, "finishedBefore"); err != nil {
+		return filter, err
+	}
+
+	if filter.Limit, err = parseLimitParam(query); err != nil {
+		return filter, err
+	}
+
+	return filter, nil
+}
+
+// parseTimeParam parses an optional RFC3339 timestamp query parameter
+func parseTimeParam(query url.Values, param string) (*time.Time, error) {
+	value := query.Get(param)
+	if value == "" {
+		return nil, nil
+	}
+
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be a RFC3339 timestamp", param)
+	}
+
+	parsed = parsed.UTC()
+	return &parsed, nil
+}
+
+// parseLimitParam parses the optional limit query parameter, 0 means no limit was set
+func parseLimitParam(query url.Values) (int, error) {
+	value := query.Get("limit")
+	if value == "" {
+		return 0, nil
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 0 {
+		return 0, errors.New("limit must be a positive number")
+	}
+
+	return limit, nil
+}
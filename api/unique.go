@@ -0,0 +1,62 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/sinderpl/AsyncTaskProcessor/task"
+)
+
+// Package api/unique deals with enqueuing tasks whose unique key matches a task which has not finished yet
+
+// DuplicatePolicy enum describing what happens to a task whose unique key matches a pending task
+type DuplicatePolicy string
+
+const (
+	DuplicateCoalesce DuplicatePolicy = "coalesce" // the pending task is returned instead of creating a new one
+	DuplicateReject   DuplicatePolicy = "reject"   // the request is rejected
+)
+
+func parseDuplicatePolicy(policy string) (DuplicatePolicy, error) {
+	switch DuplicatePolicy(policy) {
+	case "", DuplicateCoalesce:
+		return DuplicateCoalesce, nil
+	case DuplicateReject:
+		return DuplicateReject, nil
+	}
+	return "", fmt.Errorf("unsupported onDuplicate policy: %s", policy)
+}
+
+// pendingUniqueTask returns the unfinished task of the same type with the same unique key, the tasks awaiting in the
+// queue are checked first and storage covers the tasks being processed. Returns nil when there is none
+func (s *server) pendingUniqueTask(t *task.Task) (*task.Task, error) {
+	if s.queue != nil {
+		if id, ok := s.queue.PendingUniqueTask(t.TaskType, t.UniqueKey); ok {
+			return s.db.GetTaskById(id)
+		}
+	}
+
+	return s.db.GetPendingUniqueTask(t.TaskType, t.UniqueKey)
+}
+
+// duplicateResponse describes the original task returned in place of a task which was submitted again
+func duplicateResponse(original *task.Task) TaskResponse {
+	return TaskResponse{
+		Id:        original.Id,
+		TaskType:  original.TaskType,
+		Priority:  original.Priority,
+		Status:    original.Status,
+		Key:       original.Key,
+		Queue:     original.Queue,
+		Duplicate: true,
+	}
+}
+
+// uniqueConflict is the response of a task rejected because the same work is already pending
+func uniqueConflict(t *task.Task, pendingId string) (int, any) {
+	return http.StatusConflict, errorResponse{
+		Priority: t.Priority,
+		TaskType: t.TaskType,
+		Error:    fmt.Sprintf("task %s with the same unique key %s is already pending", pendingId, t.UniqueKey),
+	}
+}
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/sinderpl/AsyncTaskProcessor/task"
+)
+
+// Package api/workflows deals with batches of tasks depending on each other and reporting on their progress
+
+// WorkflowStatus enum describing the aggregate status of the tasks of a workflow
+type WorkflowStatus string
+
+const (
+	WorkflowRunning   WorkflowStatus = "Running"
+	WorkflowSucceeded WorkflowStatus = "Succeeded"
+	WorkflowFailed    WorkflowStatus = "Failed"
+	WorkflowCancelled WorkflowStatus = "Cancelled"
+)
+
+type WorkflowResponse struct {
+	Id     string                     `json:"id"`
+	Status WorkflowStatus             `json:"status"`
+	Counts map[task.CurrentStatus]int `json:"counts"`
+	Tasks  []WorkflowTaskResponse     `json:"tasks"`
+}
+
+type WorkflowTaskResponse struct {
+	TaskResponse
+	DependsOn []string        `json:"dependsOn,omitempty"` // keys of the tasks it depends on
+	Result    json.RawMessage `json:"result,omitempty"`
+}
+
+// planWorkflow validates the keys and dependencies of the batch and returns the indexes of the tasks ordered
+// so that every task comes after the tasks it depends on, batches without dependencies keep their order
+func planWorkflow(tasks []EnqueueTask) ([]int, error) {
+	indexes := make(map[string]int, len(tasks))
+	for i, t := range tasks {
+		if t.Key == "" {
+			continue
+		}
+		if _, ok := indexes[t.Key]; ok {
+			return nil, fmt.Errorf("duplicate task key: %s", t.Key)
+		}
+		indexes[t.Key] = i
+	}
+
+	remaining := make([]int, len(tasks))
+	children := make([][]int, len(tasks))
+	for i, t := range tasks {
+		for _, key := range t.DependsOn {
+			parent, ok := indexes[key]
+			if !ok {
+				return nil, fmt.Errorf("task depends on unknown key: %s", key)
+			}
+			if parent == i {
+				return nil, fmt.Errorf("task %s depends on itself", key)
+			}
+			remaining[i]++
+			children[parent] = append(children[parent], i)
+		}
+	}
+
+	order := make([]int, 0, len(tasks))
+	for i := range tasks {
+		if remaining[i] == 0 {
+			order = append(order, i)
+		}
+	}
+
+	for next := 0; next < len(order); next++ {
+		for _, child := range children[order[next]] {
+			remaining[child]--
+			if remaining[child] == 0 {
+				order = append(order, child)
+			}
+		}
+	}
+
+	if len(order) != len(tasks) {
+		return nil, fmt.Errorf("task dependencies contain a cycle")
+	}
+
+	return order, nil
+}
+
+func (s *server) handleGetWorkflow(w http.ResponseWriter, r *http.Request) error {
+	idStr, ok := mux.Vars(r)["id"]
+	if !ok {
+		return fmt.Errorf("id required to find workflow")
+	}
+
+	tasks, err := s.db.GetWorkflowTasks(idStr)
+	if err != nil {
+		slog.Error(fmt.Sprintf("failed to load workflow %s: %v", idStr, err))
+		return writeJson(w, http.StatusInternalServerError, errorResponse{Error: "failed to load workflow"})
+	}
+
+	if len(tasks) == 0 {
+		return writeJson(w, http.StatusNotFound, errorResponse{Error: "workflow not found"})
+	}
+
+	return writeJson(w, http.StatusOK, newWorkflowResponse(idStr, tasks))
+}
+
+func newWorkflowResponse(id string, tasks []*task.Task) WorkflowResponse {
+	resp := WorkflowResponse{
+		Id:     id,
+		Counts: make(map[task.CurrentStatus]int),
+		Tasks:  make([]WorkflowTaskResponse, 0, len(tasks)),
+	}
+
+	keys := make(map[string]string, len(tasks))
+	for _, t := range tasks {
+		keys[t.Id] = t.Key
+	}
+
+	for _, t := range tasks {
+		tResp := WorkflowTaskResponse{
+			TaskResponse: TaskResponse{
+				Id:       t.Id,
+				TaskType: t.TaskType,
+				Priority: t.Priority,
+				Status:   t.Status,
+				Key:      t.Key,
+				Err:      t.ErrorDetails,
+			},
+			Result: t.Result,
+		}
+		for _, id := range t.DependsOn {
+			tResp.DependsOn = append(tResp.DependsOn, keys[id])
+		}
+
+		resp.Counts[t.Status]++
+		resp.Tasks = append(resp.Tasks, tResp)
+	}
+
+	resp.Status = workflowStatus(resp.Counts)
+
+	return resp
+}
+
+// workflowStatus is running until every task finished, then failed if any task failed or was skipped,
+// cancelled if any task was cancelled and succeeded otherwise
+func workflowStatus(counts map[task.CurrentStatus]int) WorkflowStatus {
+	for status := range counts {
+		if !status.IsFinal() {
+			return WorkflowRunning
+		}
+	}
+
+	switch {
+	case counts[task.ProcessingFailed] > 0 || counts[task.ProcessingSkipped] > 0:
+		return WorkflowFailed
+	case counts[task.ProcessingCancelled] > 0:
+		return WorkflowCancelled
+	default:
+		return WorkflowSucceeded
+	}
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPlanWorkflowInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		tasks []EnqueueTask
+		err   string
+	}{
+		{
+			name:  "duplicate key",
+			tasks: []EnqueueTask{{Key: "a"}, {Key: "a"}},
+			err:   "duplicate task key: a",
+		},
+		{
+			name:  "unknown dependency",
+			tasks: []EnqueueTask{{Key: "a", DependsOn: []string{"b"}}},
+			err:   "unknown key: b",
+		},
+		{
+			name:  "self dependency",
+			tasks: []EnqueueTask{{Key: "a", DependsOn: []string{"a"}}},
+			err:   "depends on itself",
+		},
+		{
+			name: "cycle",
+			tasks: []EnqueueTask{
+				{Key: "a", DependsOn: []string{"c"}},
+				{Key: "b", DependsOn: []string{"a"}},
+				{Key: "c", DependsOn: []string{"b"}},
+			},
+			err: "cycle",
+		},
+	}
+
+	for _, tt := range tests {
+		_, err := planWorkflow(tt.tasks)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got error %v, want one containing %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestPlanWorkflowOrder(t *testing.T) {
+	tests := []struct {
+		name  string
+		tasks []EnqueueTask
+		want  []int
+	}{
+		{
+			name:  "no dependencies keep their order",
+			tasks: []EnqueueTask{{}, {Key: "a"}, {}},
+			want:  []int{0, 1, 2},
+		},
+		{
+			name: "dependencies come first",
+			tasks: []EnqueueTask{
+				{Key: "report", DependsOn: []string{"fetch", "clean"}},
+				{Key: "clean", DependsOn: []string{"fetch"}},
+				{Key: "fetch"},
+			},
+			want: []int{2, 1, 0},
+		},
+		{
+			name: "diamond",
+			tasks: []EnqueueTask{
+				{Key: "join", DependsOn: []string{"left", "right"}},
+				{Key: "left", DependsOn: []string{"root"}},
+				{Key: "right", DependsOn: []string{"root"}},
+				{Key: "root"},
+			},
+			want: []int{3, 1, 2, 0},
+		},
+	}
+
+	for _, tt := range tests {
+		order, err := planWorkflow(tt.tasks)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		if !slices.Equal(order, tt.want) {
+			t.Errorf("%s: got order %v, want %v", tt.name, order, tt.want)
+		}
+	}
+}
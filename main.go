@@ -3,32 +3,81 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"log"
+	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"gopkg.in/yaml.v2"
 
 	"github.com/sinderpl/AsyncTaskProcessor/api"
 	"github.com/sinderpl/AsyncTaskProcessor/queue"
+	"github.com/sinderpl/AsyncTaskProcessor/schedule"
 	"github.com/sinderpl/AsyncTaskProcessor/storage"
 	"github.com/sinderpl/AsyncTaskProcessor/task"
 )
 
-const defaultConfig = "config/ConfigurationLocal.yml"
+const (
+	defaultConfig              = "config/ConfigurationLocal.yml"
+	defaultShutdownGracePeriod = 30 * time.Second
+)
 
 var (
-	cfg        = Config{}
+	cfg = Config{}
 )
 
 type Config struct {
-	Api struct {
-		ListenAddr string `yaml:"listenAddr"`
+	ShutdownGracePeriod string `yaml:"shutdownGracePeriod,omitempty"`
+	Api                 struct {
+		ListenAddr        string `yaml:"listenAddr"`
+		IdempotencyWindow string `yaml:"idempotencyWindow,omitempty"`
+		DedupeWindow      string `yaml:"dedupeWindow,omitempty"`
 	} `yaml:"api"`
 	Queue struct {
-		MaxBufferSize  int `yaml:"maxBufferSize"`
-		WorkerPoolSize int `yaml:"workerPoolSize,omitempty"`
-		MaxTaskRetry   int `yaml:"maxTaskRetry"`
+		MaxBufferSize   int               `yaml:"maxBufferSize"`
+		PriorityLevels  int               `yaml:"priorityLevels,omitempty"`
+		PriorityWeights []int             `yaml:"priorityWeights,omitempty"`
+		AgingThreshold  string            `yaml:"agingThreshold,omitempty"`
+		WorkerPoolSize  int               `yaml:"workerPoolSize,omitempty"`
+		MaxTaskRetry    int               `yaml:"maxTaskRetry"`
+		TaskTimeout     string            `yaml:"taskTimeout,omitempty"`
+		TaskTimeouts    map[string]string `yaml:"taskTimeouts,omitempty"`
+		BackoffPolicies map[string]struct {
+			Strategy   string  `yaml:"strategy"`
+			Base       string  `yaml:"base"`
+			Multiplier float64 `yaml:"multiplier,omitempty"`
+			Max        string  `yaml:"max,omitempty"`
+		} `yaml:"backoffPolicies,omitempty"`
+		ConcurrencyLimits map[string]int `yaml:"concurrencyLimits,omitempty"`
+		RateLimits        map[string]struct {
+			Rate    float64 `yaml:"rate"`
+			Burst   int     `yaml:"burst,omitempty"`
+			PerUser bool    `yaml:"perUser,omitempty"`
+		} `yaml:"rateLimits,omitempty"`
+		Autoscaling struct {
+			Enabled          bool   `yaml:"enabled"`
+			MinWorkers       int    `yaml:"minWorkers"`
+			MaxWorkers       int    `yaml:"maxWorkers"`
+			CheckInterval    string `yaml:"checkInterval,omitempty"`
+			BacklogThreshold int    `yaml:"backlogThreshold,omitempty"`
+			WaitThreshold    string `yaml:"waitThreshold,omitempty"`
+			IdleDelay        string `yaml:"idleDelay,omitempty"`
+			Step             int    `yaml:"step,omitempty"`
+		} `yaml:"autoscaling,omitempty"`
 	} `yaml:"queue"`
+	Queues map[string]struct {
+		MaxBufferSize   int      `yaml:"maxBufferSize,omitempty"`
+		PriorityLevels  int      `yaml:"priorityLevels,omitempty"`
+		PriorityWeights []int    `yaml:"priorityWeights,omitempty"`
+		WorkerPoolSize  int      `yaml:"workerPoolSize,omitempty"`
+		TaskTypes       []string `yaml:"taskTypes,omitempty"`
+	} `yaml:"queues,omitempty"`
+	Schedules struct {
+		CheckInterval string `yaml:"checkInterval,omitempty"`
+	} `yaml:"schedules"`
 	Storage struct {
 		Host     string `yaml:"host"`
 		User     string `yaml:"user"`
@@ -51,42 +100,208 @@ func main() {
 		log.Fatalf("Failed to unmarshal YAML cfg data: %v", err)
 	}
 
-	// TODO graceful shutdown
+	// The queue lifecycle is driven by Shutdown rather than the signal so that requests in flight can still
+	// hand their tasks over to the queue while the server drains
 	mainCtx := context.Background()
 
-	storage, err := storage.NewPostgresStore(cfg.Storage.Host, cfg.Storage.User, cfg.Storage.DBName, cfg.Storage.Password)
+	signalCtx, stop := signal.NotifyContext(mainCtx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	gracePeriod, err := parseDuration(cfg.ShutdownGracePeriod)
+	if err != nil {
+		log.Fatalf("Invalid shutdown grace period: %v", err)
+	}
+	if gracePeriod <= 0 {
+		gracePeriod = defaultShutdownGracePeriod
+	}
+
+	db, err := storage.NewPostgresStore(cfg.Storage.Host, cfg.Storage.User, cfg.Storage.DBName, cfg.Storage.Password)
 
 	if err != nil {
 		log.Fatalf("Failed to initialise database: %v", err)
 	}
-	err = storage.Init()
+	err = db.Init()
 	if err != nil {
 		log.Fatalf("Failed to run database migration: %v", err)
 	}
 
+	taskTimeout, err := parseDuration(cfg.Queue.TaskTimeout)
+	if err != nil {
+		log.Fatalf("Invalid queue task timeout: %v", err)
+	}
+
+	taskTimeouts := make(map[task.TypeOf]time.Duration, len(cfg.Queue.TaskTimeouts))
+	for typeOf, timeout := range cfg.Queue.TaskTimeouts {
+		taskTimeouts[task.TypeOf(typeOf)], err = parseDuration(timeout)
+		if err != nil {
+			log.Fatalf("Invalid task timeout for %s: %v", typeOf, err)
+		}
+	}
+
+	backoffPolicies := make(map[task.TypeOf]*task.BackoffPolicy, len(cfg.Queue.BackoffPolicies))
+	for typeOf, policy := range cfg.Queue.BackoffPolicies {
+		backoffPolicies[task.TypeOf(typeOf)], err = task.ParseBackoffPolicy(
+			task.BackoffStrategy(policy.Strategy), policy.Base, policy.Multiplier, policy.Max)
+		if err != nil {
+			log.Fatalf("Invalid backoff policy for %s: %v", typeOf, err)
+		}
+	}
+
+	concurrencyLimits := make(map[task.TypeOf]int, len(cfg.Queue.ConcurrencyLimits))
+	for typeOf, limit := range cfg.Queue.ConcurrencyLimits {
+		concurrencyLimits[task.TypeOf(typeOf)] = limit
+	}
+
+	rateLimits := make(map[task.TypeOf]queue.RateLimit, len(cfg.Queue.RateLimits))
+	for typeOf, limit := range cfg.Queue.RateLimits {
+		if limit.Rate <= 0 {
+			log.Fatalf("Invalid rate limit for %s: rate must be positive", typeOf)
+		}
+		rateLimits[task.TypeOf(typeOf)] = queue.RateLimit{Rate: limit.Rate, Burst: limit.Burst, PerUser: limit.PerUser}
+	}
+
+	agingThreshold, err := parseDuration(cfg.Queue.AgingThreshold)
+	if err != nil {
+		log.Fatalf("Invalid queue aging threshold: %v", err)
+	}
+
+	var autoscaling *queue.Autoscaling
+	if cfg.Queue.Autoscaling.Enabled {
+		autoscaling = &queue.Autoscaling{
+			MinWorkers:       cfg.Queue.Autoscaling.MinWorkers,
+			MaxWorkers:       cfg.Queue.Autoscaling.MaxWorkers,
+			BacklogThreshold: cfg.Queue.Autoscaling.BacklogThreshold,
+			Step:             cfg.Queue.Autoscaling.Step,
+		}
+
+		if autoscaling.Interval, err = parseDuration(cfg.Queue.Autoscaling.CheckInterval); err != nil {
+			log.Fatalf("Invalid autoscaling check interval: %v", err)
+		}
+		if autoscaling.WaitThreshold, err = parseDuration(cfg.Queue.Autoscaling.WaitThreshold); err != nil {
+			log.Fatalf("Invalid autoscaling wait threshold: %v", err)
+		}
+		if autoscaling.IdleDelay, err = parseDuration(cfg.Queue.Autoscaling.IdleDelay); err != nil {
+			log.Fatalf("Invalid autoscaling idle delay: %v", err)
+		}
+	}
+
+	// Tasks can be created with any priority one of the queues supports
+	priorityLevels := cfg.Queue.PriorityLevels
+	for _, named := range cfg.Queues {
+		priorityLevels = max(priorityLevels, named.PriorityLevels)
+	}
+	task.SetPriorityLevels(priorityLevels)
+
+	namedQueues := make([]storage.QueueConfig, 0, len(cfg.Queues))
+	for name, named := range cfg.Queues {
+		taskTypes := make([]task.TypeOf, 0, len(named.TaskTypes))
+		for _, typeOf := range named.TaskTypes {
+			taskTypes = append(taskTypes, task.TypeOf(typeOf))
+		}
+
+		namedQueues = append(namedQueues, storage.QueueConfig{
+			Name:            name,
+			MaxBufferSize:   named.MaxBufferSize,
+			PriorityLevels:  named.PriorityLevels,
+			PriorityWeights: named.PriorityWeights,
+			WorkerPoolSize:  named.WorkerPoolSize,
+			TaskTypes:       taskTypes,
+		})
+	}
+
 	taskChan := make(chan []*task.Task)
 
-	q, err := queue.CreateQueue(mainCtx,
-		queue.WithMainQueue(&taskChan),
-		queue.WithMaxBufferSize(cfg.Queue.MaxBufferSize),
-		queue.WithMaxWorkerPoolSize(cfg.Queue.WorkerPoolSize),
-		queue.WithMaxTaskRetry(cfg.Queue.MaxTaskRetry),
-		queue.WithStorage(storage))
+	manager, err := queue.CreateManager(mainCtx,
+		queue.WithTaskSource(&taskChan),
+		queue.WithManagerStorage(db),
+		queue.WithSharedConcurrencyLimits(concurrencyLimits),
+		queue.WithSharedRateLimits(rateLimits),
+		queue.WithQueueDefaults(
+			queue.WithAgingThreshold(agingThreshold),
+			queue.WithMaxTaskRetry(cfg.Queue.MaxTaskRetry),
+			queue.WithTaskTimeout(taskTimeout),
+			queue.WithTaskTimeouts(taskTimeouts),
+			queue.WithBackoffPolicies(backoffPolicies)),
+		// The queue section configures the default queue, the only one which autoscales
+		queue.WithDefaultQueue(storage.QueueConfig{
+			MaxBufferSize:   cfg.Queue.MaxBufferSize,
+			PriorityLevels:  cfg.Queue.PriorityLevels,
+			PriorityWeights: cfg.Queue.PriorityWeights,
+			WorkerPoolSize:  cfg.Queue.WorkerPoolSize,
+		}, queue.WithAutoscaling(autoscaling)),
+		queue.WithNamedQueues(namedQueues))
+
+	if err != nil {
+		log.Fatalf("failed to initialize queues: %v", err)
+	}
+
+	if err := manager.Start(); err != nil {
+		log.Fatalf("failed to start queues: %v", err)
+	}
+
+	checkInterval, err := parseDuration(cfg.Schedules.CheckInterval)
+	if err != nil {
+		log.Fatalf("Invalid schedule check interval: %v", err)
+	}
+
+	runner, err := schedule.CreateRunner(mainCtx,
+		schedule.WithStore(db),
+		schedule.WithTaskChan(&taskChan),
+		schedule.WithInterval(checkInterval))
+
+	if err != nil {
+		log.Fatalf("failed to initialize schedule runner: %v", err)
+	}
+
+	runner.Start()
 
+	idempotencyWindow, err := parseDuration(cfg.Api.IdempotencyWindow)
 	if err != nil {
-		log.Fatalf("failed to initialize queue: %v", err)
+		log.Fatalf("Invalid idempotency window: %v", err)
 	}
 
-	q.Start()
+	dedupeWindow, err := parseDuration(cfg.Api.DedupeWindow)
+	if err != nil {
+		log.Fatalf("Invalid dedupe window: %v", err)
+	}
 
 	server := api.CreateApiServer(
 		api.WithListenAddr(cfg.Api.ListenAddr),
 		api.WithQueue(&taskChan),
-		api.WithStorage(storage))
+		api.WithStorage(db),
+		api.WithQueueManager(manager),
+		api.WithIdempotencyWindow(idempotencyWindow),
+		api.WithDedupeWindow(dedupeWindow))
 
-	err = server.Run()
-	if err != nil {
-		log.Fatalf("failed to start up sever: %v", err)
+	go func() {
+		if err := server.Run(); err != nil {
+			log.Fatalf("failed to start up sever: %v", err)
+		}
+	}()
+
+	<-signalCtx.Done()
+	slog.Info("shutdown signal received, draining", "gracePeriod", gracePeriod.String())
+
+	shutdownCtx, cancel := context.WithTimeout(mainCtx, gracePeriod)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error(fmt.Sprintf("failed to shut down server gracefully: %v", err))
+	}
+
+	// Stop firing schedules before the queues stop listening for new tasks
+	runner.Shutdown()
+	manager.Shutdown(shutdownCtx)
+
+	if err := db.Close(); err != nil {
+		slog.Error(fmt.Sprintf("failed to close database connection: %v", err))
 	}
+}
 
+// parseDuration parses optional duration config values, an empty value is a zero duration
+func parseDuration(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(value)
 }
@@ -0,0 +1,123 @@
+package queue
+
+import (
+	"expvar"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Package queue/autoscaler deals with growing the worker pool when tasks pile up and shrinking it when idle
+
+// Autoscaling bounds the worker pool and sets when it is scaled. The pool grows by Step workers when more than
+// BacklogThreshold tasks are ready to be dispatched or the oldest of them waited longer than WaitThreshold, and
+// shrinks by Step workers once it has had no backlog and idle workers for IdleDelay
+type Autoscaling struct {
+	MinWorkers       int
+	MaxWorkers       int
+	Interval         time.Duration // how often the backlog is checked
+	BacklogThreshold int           // 0 disables scaling on the backlog size
+	WaitThreshold    time.Duration // 0 disables scaling on the wait time
+	IdleDelay        time.Duration
+	Step             int
+}
+
+// autoscalerMetrics exposes the autoscaler state and decisions on /debug/vars
+var autoscalerMetrics = expvar.NewMap("autoscaler")
+
+// autoscaler resizes the worker pool of the queue within the configured bounds
+type autoscaler struct {
+	q         *Queue
+	cfg       Autoscaling
+	idleSince time.Time // zero while the pool is busy
+}
+
+// run checks the backlog every interval until the queue is shut down
+func (a *autoscaler) run() {
+	ticker := time.NewTicker(a.cfg.Interval)
+	defer ticker.Stop()
+
+	slog.Info(fmt.Sprintf("autoscaler started, keeping between %d and %d workers", a.cfg.MinWorkers, a.cfg.MaxWorkers))
+	for {
+		select {
+		case <-a.q.ctx.Done():
+			slog.Info("autoscaler stopped, queue context cancelled")
+			return
+		case now := <-ticker.C:
+			a.check(now.UTC())
+		}
+	}
+}
+
+// check resizes the pool once based on the current backlog, the oldest wait and the busy workers
+func (a *autoscaler) check(now time.Time) {
+	backlog, oldest := a.q.backlog(now)
+	backlog += len(a.q.dispatchChan)
+	busy := a.q.workerPool.busy()
+	size := a.q.workerPool.Size()
+
+	autoscalerMetrics.Set("workers", intVar(size))
+	autoscalerMetrics.Set("busyWorkers", intVar(busy))
+	autoscalerMetrics.Set("backlog", intVar(backlog))
+	autoscalerMetrics.Set("oldestWaitMs", intVar(int(oldest.Milliseconds())))
+
+	target, reason := size, ""
+	switch {
+	case size < a.cfg.MinWorkers:
+		target, reason = a.cfg.MinWorkers, "below the minimum"
+	case size > a.cfg.MaxWorkers:
+		target, reason = a.cfg.MaxWorkers, "above the maximum"
+	case a.cfg.BacklogThreshold > 0 && backlog > a.cfg.BacklogThreshold:
+		target, reason = size+a.cfg.Step, fmt.Sprintf("backlog of %d tasks above %d", backlog, a.cfg.BacklogThreshold)
+	case a.cfg.WaitThreshold > 0 && oldest > a.cfg.WaitThreshold:
+		target, reason = size+a.cfg.Step, fmt.Sprintf("oldest task waited %v, above %v", oldest, a.cfg.WaitThreshold)
+	case backlog == 0 && busy < size:
+		if a.idleSince.IsZero() {
+			a.idleSince = now
+		}
+		if idle := now.Sub(a.idleSince); idle >= a.cfg.IdleDelay {
+			target, reason = size-a.cfg.Step, fmt.Sprintf("%d of %d workers idle for %v", size-busy, size, idle)
+		}
+	}
+
+	if backlog > 0 || busy >= size {
+		a.idleSince = time.Time{}
+	}
+
+	target = min(max(target, a.cfg.MinWorkers), a.cfg.MaxWorkers)
+	if target == size {
+		return
+	}
+
+	if err := a.q.ResizeWorkerPool(target); err != nil {
+		slog.Error(fmt.Sprintf("autoscaler failed to resize worker pool to %d: %v", target, err))
+		return
+	}
+
+	// A shrink restarts the idle delay so the pool steps down gradually
+	a.idleSince = time.Time{}
+
+	decision := fmt.Sprintf("scaled workers from %d to %d: %s", size, target, reason)
+	slog.Info(fmt.Sprintf("autoscaler %s", decision))
+
+	if target > size {
+		autoscalerMetrics.Add("scaleUps", 1)
+	} else {
+		autoscalerMetrics.Add("scaleDowns", 1)
+	}
+	autoscalerMetrics.Set("workers", intVar(target))
+	autoscalerMetrics.Set("lastDecision", stringVar(decision))
+	autoscalerMetrics.Set("lastDecisionAt", stringVar(now.Format(time.RFC3339)))
+}
+
+func intVar(v int) *expvar.Int {
+	i := new(expvar.Int)
+	i.Set(int64(v))
+	return i
+}
+
+func stringVar(v string) *expvar.String {
+	s := new(expvar.String)
+	s.Set(v)
+	return s
+}
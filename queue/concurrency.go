@@ -0,0 +1,68 @@
+package queue
+
+import (
+	"sync"
+
+	"github.com/sinderpl/AsyncTaskProcessor/task"
+)
+
+// Package queue/concurrency deals with capping how many tasks of a type execute at once
+
+// concurrency counts the executing tasks per type against their limits, the queues of a manager share one so a
+// limit holds across every queue
+type concurrency struct {
+	mutex     sync.Mutex
+	limits    map[task.TypeOf]int // max tasks of a type executing at once, types without one are unlimited
+	executing map[task.TypeOf]int // dispatched tasks per type whose result has not come back from the workers
+	waiters   []func()            // wake up the dispatchers once a slot frees up
+}
+
+func newConcurrency(limits map[task.TypeOf]int) *concurrency {
+	valid := make(map[task.TypeOf]int, len(limits))
+	for typeOf, limit := range limits {
+		if limit >= 1 {
+			valid[typeOf] = limit
+		}
+	}
+
+	return &concurrency{
+		limits:    valid,
+		executing: make(map[task.TypeOf]int),
+	}
+}
+
+// subscribe wakes up the dispatcher whenever a slot of a limited type frees up
+func (c *concurrency) subscribe(notify func()) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.waiters = append(c.waiters, notify)
+}
+
+// available reports whether another task of the type can execute, the caller holds the mutex
+func (c *concurrency) available(typeOf task.TypeOf) bool {
+	limit, ok := c.limits[typeOf]
+	return !ok || c.executing[typeOf] < limit
+}
+
+// acquire counts a dispatched task of the type, the caller holds the mutex
+func (c *concurrency) acquire(typeOf task.TypeOf) {
+	c.executing[typeOf]++
+}
+
+// release frees the slot of a task of the type, every dispatcher waiting for the type is woken up
+func (c *concurrency) release(typeOf task.TypeOf) {
+	c.mutex.Lock()
+	c.executing[typeOf]--
+	_, limited := c.limits[typeOf]
+	waiters := c.waiters
+	c.mutex.Unlock()
+
+	if !limited {
+		return
+	}
+
+	for _, notify := range waiters {
+		notify()
+	}
+}
@@ -0,0 +1,133 @@
+package queue
+
+import (
+	"sync"
+
+	"github.com/sinderpl/AsyncTaskProcessor/task"
+)
+
+// Package queue/dependencies keeps track of the workflow tasks which wait for their dependencies to finish
+
+// dependencies holds the blocked tasks until every task they depend on succeeded or one of them did not
+type dependencies struct {
+	mutex     sync.Mutex
+	blocked   map[string]*task.Task // blocked tasks by id
+	remaining map[string]int        // amount of dependencies of a blocked task which have not succeeded yet
+	children  map[string][]string   // ids of the blocked tasks waiting on a task
+}
+
+func newDependencies() *dependencies {
+	return &dependencies{
+		blocked:   make(map[string]*task.Task),
+		remaining: make(map[string]int),
+		children:  make(map[string][]string),
+	}
+}
+
+// add holds the task until its dependencies finish, status returns the stored status of a dependency so a task
+// recovered after its dependencies finished is resolved straight away. Returns whether the task is still blocked
+// and if not whether all of its dependencies succeeded
+func (d *dependencies) add(t *task.Task, status func(id string) task.CurrentStatus) (bool, bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	// The lock is held while reading the stored statuses, a dependency finishing in the meantime resolves
+	// only after the task is registered so it can't be missed
+	pending := make([]string, 0, len(t.DependsOn))
+	for _, id := range t.DependsOn {
+		switch s := status(id); {
+		case s == task.ProcessingSuccess:
+		case s.IsFinal() || s == "":
+			return false, false
+		default:
+			pending = append(pending, id)
+		}
+	}
+
+	if len(pending) == 0 {
+		return false, true
+	}
+
+	d.blocked[t.Id] = t
+	d.remaining[t.Id] = len(pending)
+	for _, id := range pending {
+		d.children[id] = append(d.children[id], t.Id)
+	}
+
+	return true, false
+}
+
+// resolve is called once a task reached a final status. Returns the blocked tasks whose dependencies all succeeded
+// and the blocked tasks which can never run because the task, or a task they depend on through it, did not succeed
+func (d *dependencies) resolve(id string, succeeded bool) ([]*task.Task, []*task.Task) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	released := make([]*task.Task, 0)
+	skipped := make([]*task.Task, 0)
+
+	var walk func(id string, succeeded bool)
+	walk = func(id string, succeeded bool) {
+		children := d.children[id]
+		delete(d.children, id)
+
+		for _, childId := range children {
+			// A child already skipped through another dependency is no longer blocked
+			child, ok := d.blocked[childId]
+			if !ok {
+				continue
+			}
+
+			if succeeded {
+				d.remaining[childId]--
+				if d.remaining[childId] > 0 {
+					continue
+				}
+				d.unblock(childId)
+				released = append(released, child)
+				continue
+			}
+
+			d.unblock(childId)
+			skipped = append(skipped, child)
+			walk(childId, false)
+		}
+	}
+	walk(id, succeeded)
+
+	return released, skipped
+}
+
+// remove takes a blocked task out, returns nil when it is not blocked
+func (d *dependencies) remove(id string) *task.Task {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	t, ok := d.blocked[id]
+	if !ok {
+		return nil
+	}
+	d.unblock(id)
+
+	return t
+}
+
+// findUnique returns the blocked task of the type with the unique key, nil when there is none
+func (d *dependencies) findUnique(taskType task.TypeOf, uniqueKey string) *task.Task {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for _, t := range d.blocked {
+		if t.TaskType == taskType && t.UniqueKey == uniqueKey {
+			return t
+		}
+	}
+
+	return nil
+}
+
+// unblock forgets the blocked task, its id is left in the children of its other dependencies and ignored there
+func (d *dependencies) unblock(id string) {
+	delete(d.blocked, id)
+	delete(d.remaining, id)
+}
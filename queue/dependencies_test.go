@@ -0,0 +1,119 @@
+package queue
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/sinderpl/AsyncTaskProcessor/task"
+)
+
+// pendingStatus reports every dependency as not finished yet
+func pendingStatus(string) task.CurrentStatus { return task.ProcessingAwaiting }
+
+func taskIds(tasks []*task.Task) []string {
+	result := make([]string, 0, len(tasks))
+	for _, t := range tasks {
+		result = append(result, t.Id)
+	}
+	slices.Sort(result)
+	return result
+}
+
+func TestDependenciesAdd(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    task.CurrentStatus
+		blocked   bool
+		succeeded bool
+	}{
+		{"dependency pending", task.ProcessingAwaiting, true, false},
+		{"dependency succeeded", task.ProcessingSuccess, false, true},
+		{"dependency failed", task.ProcessingFailed, false, false},
+		{"dependency cancelled", task.ProcessingCancelled, false, false},
+		{"dependency missing", "", false, false},
+	}
+
+	for _, tt := range tests {
+		d := newDependencies()
+		status := func(string) task.CurrentStatus { return tt.status }
+
+		blocked, succeeded := d.add(&task.Task{Id: "child", DependsOn: []string{"parent"}}, status)
+		if blocked != tt.blocked || succeeded != tt.succeeded {
+			t.Errorf("%s: got blocked %v succeeded %v, want %v %v", tt.name, blocked, succeeded, tt.blocked, tt.succeeded)
+		}
+	}
+}
+
+func TestDependenciesResolve(t *testing.T) {
+	type resolution struct {
+		id        string
+		succeeded bool
+		released  []string
+		skipped   []string
+	}
+
+	tests := []struct {
+		name    string
+		tasks   []*task.Task
+		resolve []resolution
+	}{
+		{
+			name:  "released once every parent succeeded",
+			tasks: []*task.Task{{Id: "child", DependsOn: []string{"a", "b"}}},
+			resolve: []resolution{
+				{id: "a", succeeded: true},
+				{id: "b", succeeded: true, released: []string{"child"}},
+			},
+		},
+		{
+			name:  "skipped when a parent fails after another succeeded",
+			tasks: []*task.Task{{Id: "child", DependsOn: []string{"a", "b"}}},
+			resolve: []resolution{
+				{id: "a", succeeded: true},
+				{id: "b", succeeded: false, skipped: []string{"child"}},
+			},
+		},
+		{
+			name: "skip is transitive",
+			tasks: []*task.Task{
+				{Id: "child", DependsOn: []string{"root"}},
+				{Id: "grandchild", DependsOn: []string{"child"}},
+				{Id: "sibling", DependsOn: []string{"other"}},
+			},
+			resolve: []resolution{
+				{id: "root", succeeded: false, skipped: []string{"child", "grandchild"}},
+				{id: "other", succeeded: true, released: []string{"sibling"}},
+			},
+		},
+		{
+			name: "skipped through one parent is not released through another",
+			tasks: []*task.Task{
+				{Id: "child", DependsOn: []string{"a"}},
+				{Id: "join", DependsOn: []string{"child", "b"}},
+			},
+			resolve: []resolution{
+				{id: "a", succeeded: false, skipped: []string{"child", "join"}},
+				{id: "b", succeeded: true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		d := newDependencies()
+		for _, blocked := range tt.tasks {
+			if ok, _ := d.add(blocked, pendingStatus); !ok {
+				t.Fatalf("%s: task %s was not blocked", tt.name, blocked.Id)
+			}
+		}
+
+		for _, r := range tt.resolve {
+			released, skipped := d.resolve(r.id, r.succeeded)
+			if got := taskIds(released); !slices.Equal(got, r.released) {
+				t.Errorf("%s: resolving %s released %v, want %v", tt.name, r.id, got, r.released)
+			}
+			if got := taskIds(skipped); !slices.Equal(got, r.skipped) {
+				t.Errorf("%s: resolving %s skipped %v, want %v", tt.name, r.id, got, r.skipped)
+			}
+		}
+	}
+}
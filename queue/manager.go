@@ -0,0 +1,650 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/sinderpl/AsyncTaskProcessor/storage"
+	"github.com/sinderpl/AsyncTaskProcessor/task"
+)
+
+// Package queue/manager deals with running several named queues, each with its own worker pool, and routing
+// the tasks coming in to them
+
+// DefaultQueue processes every task which is neither routed by its type nor sent to a named queue
+const DefaultQueue = "default"
+
+// maxQueueNameLength matches the name column of the queues table
+const maxQueueNameLength = 100
+
+var (
+	// ErrInvalidQueue is returned when a queue can't be created with its config
+	ErrInvalidQueue = errors.New("invalid queue")
+	// ErrUnknownQueue is returned when there is no queue with the name
+	ErrUnknownQueue = errors.New("unknown queue")
+)
+
+type managerOption func(m *Manager)
+
+// namedQueue is a queue held by the manager with the chan it receives its tasks on
+type namedQueue struct {
+	q        *Queue
+	cfg      storage.QueueConfig
+	taskChan chan []*task.Task
+}
+
+// QueueInfo describes a named queue and its current load
+type QueueInfo struct {
+	storage.QueueConfig
+	Workers int  `json:"workers"`
+	Backlog int  `json:"backlog"` // tasks held by the queue which have not been picked up by a worker
+	Paused  bool `json:"paused"`
+}
+
+// queueDefinition is a queue from the config, created along with the manager
+type queueDefinition struct {
+	cfg  storage.QueueConfig
+	opts []option
+}
+
+// Manager receives the tasks on the main chan and routes them to the named queues. A task goes to the queue it
+// was sent to, otherwise to the queue its type is routed to and otherwise to the default queue
+type Manager struct {
+	parent context.Context // the queues run with it so they are only stopped by their own shutdown
+	ctx    context.Context // stops routing tasks
+	cancel context.CancelFunc
+	db     storage.Storage
+
+	mainTaskChan *chan []*task.Task
+	defaults     []option     // applied to every queue before its own options
+	concurrency  *concurrency // shared so a concurrency limit holds across the queues
+	rateLimiter  *rateLimiter // shared so a rate limit holds across the queues
+	definitions  []queueDefinition
+	dependencies *dependencies // shared so a workflow can span queues
+
+	mutex  sync.RWMutex
+	queues map[string]*namedQueue
+	order  []string                 // queue names in the order they were created
+	routes map[task.TypeOf]string   // queue name per routed task type
+	paused map[task.TypeOf]struct{} // task types paused in every queue
+
+	done chan struct{} // closed once the manager stopped routing tasks
+}
+
+// CreateManager creates the manager and its queues from the config, the default queue is created with the
+// defaults when it is not configured
+func CreateManager(ctx context.Context, opts ...managerOption) (*Manager, error) {
+	m := &Manager{
+		parent:       ctx,
+		dependencies: newDependencies(),
+		concurrency:  newConcurrency(nil),
+		rateLimiter:  newRateLimiter(nil),
+		queues:       make(map[string]*namedQueue),
+		routes:       make(map[task.TypeOf]string),
+		paused:       make(map[task.TypeOf]struct{}),
+		done:         make(chan struct{}),
+	}
+
+	m.ctx, m.cancel = context.WithCancel(ctx)
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	if m.mainTaskChan == nil {
+		return nil, fmt.Errorf("main task channel must be set")
+	}
+
+	if m.db == nil {
+		return nil, fmt.Errorf("storage must be set")
+	}
+
+	hasDefault := slices.ContainsFunc(m.definitions, func(d queueDefinition) bool { return d.cfg.Name == DefaultQueue })
+	if !hasDefault {
+		m.definitions = append([]queueDefinition{{cfg: storage.QueueConfig{Name: DefaultQueue}}}, m.definitions...)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, d := range m.definitions {
+		if err := m.addQueue(d.cfg, d.opts); err != nil {
+			return nil, fmt.Errorf("failed to create queue %s: %v", d.cfg.Name, err)
+		}
+	}
+
+	return m, nil
+}
+
+// WithTaskSource *required* the manager routes the tasks coming in on this chan
+func WithTaskSource(taskChan *chan []*task.Task) managerOption {
+	return func(m *Manager) {
+		m.mainTaskChan = taskChan
+	}
+}
+
+// WithManagerStorage *required* the storage shared by every queue, queues created at runtime are persisted to it
+func WithManagerStorage(db storage.Storage) managerOption {
+	return func(m *Manager) {
+		m.db = db
+	}
+}
+
+// WithSharedConcurrencyLimits sets the max amount of tasks of a type executing at once across every queue,
+// see WithConcurrencyLimits
+func WithSharedConcurrencyLimits(limits map[task.TypeOf]int) managerOption {
+	return func(m *Manager) {
+		m.concurrency = newConcurrency(limits)
+	}
+}
+
+// WithSharedRateLimits sets the rate limit per task type across every queue, see WithRateLimits
+func WithSharedRateLimits(limits map[task.TypeOf]RateLimit) managerOption {
+	return func(m *Manager) {
+		m.rateLimiter = newRateLimiter(limits)
+	}
+}
+
+// WithQueueDefaults options applied to every queue such as retries and timeouts, the concurrency and rate limits
+// of the queues are always the ones shared by the manager
+func WithQueueDefaults(opts ...option) managerOption {
+	return func(m *Manager) {
+		m.defaults = append(m.defaults, opts...)
+	}
+}
+
+// WithDefaultQueue configures the default queue, opts only apply to it on top of the defaults
+func WithDefaultQueue(cfg storage.QueueConfig, opts ...option) managerOption {
+	return func(m *Manager) {
+		cfg.Name = DefaultQueue
+		cfg.TaskTypes = nil
+		m.definitions = append(m.definitions, queueDefinition{cfg: cfg, opts: opts})
+	}
+}
+
+// WithNamedQueues adds the named queues from the config
+func WithNamedQueues(cfgs []storage.QueueConfig) managerOption {
+	return func(m *Manager) {
+		for _, cfg := range cfgs {
+			m.definitions = append(m.definitions, queueDefinition{cfg: cfg})
+		}
+	}
+}
+
+// Start recreates the queues created at runtime, restores the pauses, recovers the unfinished tasks into the queues
+// they are routed to and starts routing new tasks
+func (m *Manager) Start() error {
+	persisted, err := m.db.ListQueueConfigs()
+	if err != nil {
+		return fmt.Errorf("failed to load queues: %v", err)
+	}
+
+	m.mutex.Lock()
+	for _, cfg := range persisted {
+		if _, ok := m.queues[cfg.Name]; ok {
+			slog.Warn(fmt.Sprintf("queue %s is configured, its stored config is ignored", cfg.Name))
+			continue
+		}
+		// A queue which can no longer be created leaves its tasks to the default queue
+		if err := m.addQueue(*cfg, nil); err != nil {
+			slog.Error(fmt.Sprintf("failed to recreate queue %s: %v", cfg.Name, err))
+		}
+	}
+	m.mutex.Unlock()
+
+	// Pauses are restored before the tasks are recovered so nothing paused is dispatched
+	pauses, err := m.db.ListPauses()
+	if err != nil {
+		return fmt.Errorf("failed to load pauses: %v", err)
+	}
+	for _, pause := range pauses {
+		m.restore(pause)
+	}
+
+	tasks, err := m.db.GetUnfinishedTasks()
+	if err != nil {
+		return fmt.Errorf("failed to load unfinished tasks: %v", err)
+	}
+
+	byQueue := m.group(tasks)
+	for _, nq := range m.list() {
+		if err := nq.q.start(byQueue[nq.cfg.Name]); err != nil {
+			return fmt.Errorf("failed to start queue %s: %v", nq.cfg.Name, err)
+		}
+	}
+
+	go m.awaitTasks()
+
+	return nil
+}
+
+// Shutdown stops routing new tasks and shuts every queue down, see Queue.Shutdown
+func (m *Manager) Shutdown(ctx context.Context) {
+	m.cancel()
+	<-m.done
+
+	var wg sync.WaitGroup
+	for _, nq := range m.list() {
+		wg.Add(1)
+		go func(nq *namedQueue) {
+			defer wg.Done()
+			nq.q.Shutdown(ctx)
+		}(nq)
+	}
+	wg.Wait()
+}
+
+// awaitTasks routes the tasks coming in on the main chan to their queues
+func (m *Manager) awaitTasks() {
+	defer close(m.done)
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			slog.Info("queue manager stopped routing tasks, context cancelled")
+			return
+		case tasks, ok := <-*m.mainTaskChan:
+			if !ok {
+				slog.Error("reading from empty channel")
+				return
+			}
+
+			for name, routed := range m.group(tasks) {
+				m.mutex.RLock()
+				nq := m.queues[name]
+				m.mutex.RUnlock()
+
+				select {
+				case nq.taskChan <- routed:
+				case <-m.ctx.Done():
+					// The tasks are already stored, they are recovered on the next start
+					return
+				}
+			}
+		}
+	}
+}
+
+// CreateQueue creates, persists and starts a named queue at runtime
+func (m *Manager) CreateQueue(cfg storage.QueueConfig) (*QueueInfo, error) {
+	if m.ctx.Err() != nil {
+		return nil, errors.New("queue manager is shutting down")
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if err := m.validate(cfg); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidQueue, err)
+	}
+
+	cfg.CreatedAt = time.Now().UTC()
+	if err := m.db.CreateQueueConfig(&cfg); err != nil {
+		return nil, fmt.Errorf("failed to persist queue: %v", err)
+	}
+
+	if err := m.addQueue(cfg, nil); err != nil {
+		return nil, err
+	}
+
+	nq := m.queues[cfg.Name]
+	if err := nq.q.start(nil); err != nil {
+		return nil, fmt.Errorf("failed to start queue: %v", err)
+	}
+
+	slog.Info(fmt.Sprintf("queue %s created with %d workers", cfg.Name, nq.cfg.WorkerPoolSize))
+
+	info := nq.info()
+	return &info, nil
+}
+
+// Queues describes every queue in the order they were created
+func (m *Manager) Queues() []QueueInfo {
+	queues := m.list()
+
+	infos := make([]QueueInfo, 0, len(queues))
+	for _, nq := range queues {
+		infos = append(infos, nq.info())
+	}
+
+	return infos
+}
+
+// RouteTask returns the queue a task of the type is processed by, a named queue has to exist
+func (m *Manager) RouteTask(taskType task.TypeOf, queue string) (string, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	if queue != "" {
+		if _, ok := m.queues[queue]; !ok {
+			return "", fmt.Errorf("%w: %s", ErrUnknownQueue, queue)
+		}
+		return queue, nil
+	}
+
+	return m.route(&task.Task{TaskType: taskType}), nil
+}
+
+// CancelTask cancels the task in whichever queue holds it, see Queue.CancelTask
+func (m *Manager) CancelTask(id string) (bool, error) {
+	for _, nq := range m.list() {
+		if cancelled, err := nq.q.CancelTask(id); cancelled || err != nil {
+			return cancelled, err
+		}
+	}
+
+	return false, nil
+}
+
+// PendingUniqueTask looks for the task with the unique key in every queue, see Queue.PendingUniqueTask
+func (m *Manager) PendingUniqueTask(taskType task.TypeOf, uniqueKey string) (string, bool) {
+	for _, nq := range m.list() {
+		if id, ok := nq.q.PendingUniqueTask(taskType, uniqueKey); ok {
+			return id, true
+		}
+	}
+
+	return "", false
+}
+
+// ResizeWorkerPool resizes the worker pool of the queue, see Queue.ResizeWorkerPool
+func (m *Manager) ResizeWorkerPool(queue string, size int) error {
+	nq, err := m.get(queue)
+	if err != nil {
+		return err
+	}
+
+	return nq.q.ResizeWorkerPool(size)
+}
+
+// WorkerPoolSize returns the amount of workers of the queue
+func (m *Manager) WorkerPoolSize(queue string) (int, error) {
+	nq, err := m.get(queue)
+	if err != nil {
+		return 0, err
+	}
+
+	return nq.q.WorkerPoolSize(), nil
+}
+
+// PauseQueue stops the queue from dispatching tasks until it is resumed, the pause survives a restart
+func (m *Manager) PauseQueue(name string) error {
+	nq, err := m.get(name)
+	if err != nil {
+		return err
+	}
+
+	pause := &storage.Pause{Scope: storage.PauseScopeQueue, Name: name, PausedAt: time.Now().UTC()}
+	if err := m.db.CreatePause(pause); err != nil {
+		return fmt.Errorf("failed to persist pause: %v", err)
+	}
+
+	nq.q.Pause()
+	slog.Info(fmt.Sprintf("queue %s paused", name))
+
+	return nil
+}
+
+// ResumeQueue dispatches the tasks of a paused queue again
+func (m *Manager) ResumeQueue(name string) error {
+	nq, err := m.get(name)
+	if err != nil {
+		return err
+	}
+
+	if _, err := m.db.DeletePause(storage.PauseScopeQueue, name); err != nil {
+		return fmt.Errorf("failed to remove pause: %v", err)
+	}
+
+	nq.q.Resume()
+	slog.Info(fmt.Sprintf("queue %s resumed", name))
+
+	return nil
+}
+
+// PauseTaskType stops every queue from dispatching tasks of the type until it is resumed, the pause survives a restart
+func (m *Manager) PauseTaskType(typeOf task.TypeOf) error {
+	if !slices.Contains(task.RegisteredTypes(), typeOf) {
+		return fmt.Errorf("unsupported task type: %s", typeOf)
+	}
+
+	pause := &storage.Pause{Scope: storage.PauseScopeTaskType, Name: string(typeOf), PausedAt: time.Now().UTC()}
+	if err := m.db.CreatePause(pause); err != nil {
+		return fmt.Errorf("failed to persist pause: %v", err)
+	}
+
+	m.mutex.Lock()
+	m.paused[typeOf] = struct{}{}
+	m.mutex.Unlock()
+
+	for _, nq := range m.list() {
+		nq.q.PauseTaskType(typeOf)
+	}
+	slog.Info(fmt.Sprintf("task type %s paused", typeOf))
+
+	return nil
+}
+
+// ResumeTaskType dispatches tasks of the type again
+func (m *Manager) ResumeTaskType(typeOf task.TypeOf) error {
+	if _, err := m.db.DeletePause(storage.PauseScopeTaskType, string(typeOf)); err != nil {
+		return fmt.Errorf("failed to remove pause: %v", err)
+	}
+
+	m.mutex.Lock()
+	delete(m.paused, typeOf)
+	m.mutex.Unlock()
+
+	for _, nq := range m.list() {
+		nq.q.ResumeTaskType(typeOf)
+	}
+	slog.Info(fmt.Sprintf("task type %s resumed", typeOf))
+
+	return nil
+}
+
+// PausedTaskTypes returns the paused task types in order
+func (m *Manager) PausedTaskTypes() []task.TypeOf {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	paused := make([]task.TypeOf, 0, len(m.paused))
+	for typeOf := range m.paused {
+		paused = append(paused, typeOf)
+	}
+	slices.Sort(paused)
+
+	return paused
+}
+
+// restore applies a stored pause, a pause of a queue which no longer exists is ignored
+func (m *Manager) restore(pause *storage.Pause) {
+	switch pause.Scope {
+	case storage.PauseScopeQueue:
+		nq, err := m.get(pause.Name)
+		if err != nil {
+			slog.Warn(fmt.Sprintf("paused queue %s no longer exists", pause.Name))
+			return
+		}
+		nq.q.Pause()
+		slog.Info(fmt.Sprintf("queue %s is paused since %s", pause.Name, pause.PausedAt.Format(time.RFC3339)))
+	case storage.PauseScopeTaskType:
+		typeOf := task.TypeOf(pause.Name)
+
+		m.mutex.Lock()
+		m.paused[typeOf] = struct{}{}
+		m.mutex.Unlock()
+
+		for _, nq := range m.list() {
+			nq.q.PauseTaskType(typeOf)
+		}
+		slog.Info(fmt.Sprintf("task type %s is paused since %s", pause.Name, pause.PausedAt.Format(time.RFC3339)))
+	default:
+		slog.Warn(fmt.Sprintf("unknown pause scope %s of %s", pause.Scope, pause.Name))
+	}
+}
+
+// validate checks the queue can be added, the caller holds the mutex
+func (m *Manager) validate(cfg storage.QueueConfig) error {
+	if cfg.Name == "" {
+		return errors.New("queue name must be set")
+	}
+
+	if len(cfg.Name) > maxQueueNameLength {
+		return fmt.Errorf("queue name can't be longer than %d characters", maxQueueNameLength)
+	}
+
+	if _, ok := m.queues[cfg.Name]; ok {
+		return fmt.Errorf("queue %s already exists", cfg.Name)
+	}
+
+	levels := cfg.PriorityLevels
+	if levels < 1 {
+		levels = task.PriorityLevels()
+	}
+	if len(cfg.PriorityWeights) > levels {
+		return fmt.Errorf("%d priority weights set for %d priority levels", len(cfg.PriorityWeights), levels)
+	}
+
+	registered := task.RegisteredTypes()
+	for _, typeOf := range cfg.TaskTypes {
+		if !slices.Contains(registered, typeOf) {
+			return fmt.Errorf("unsupported task type: %s", typeOf)
+		}
+		if queue, ok := m.routes[typeOf]; ok {
+			return fmt.Errorf("task type %s is already routed to queue %s", typeOf, queue)
+		}
+	}
+
+	return nil
+}
+
+// addQueue creates the queue and routes its task types to it, the caller holds the mutex
+func (m *Manager) addQueue(cfg storage.QueueConfig, opts []option) error {
+	if err := m.validate(cfg); err != nil {
+		return err
+	}
+
+	// Queues without their own priority levels support every priority a task can be created with,
+	// higher priorities share the top level of a queue with fewer levels
+	if cfg.PriorityLevels < 1 {
+		cfg.PriorityLevels = task.PriorityLevels()
+	}
+
+	taskChan := make(chan []*task.Task)
+	queueOpts := append(slices.Clone(m.defaults),
+		WithMainQueue(&taskChan),
+		WithStorage(m.db),
+		WithMaxBufferSize(cfg.MaxBufferSize),
+		WithPriorityLevels(cfg.PriorityLevels),
+		WithMaxWorkerPoolSize(cfg.WorkerPoolSize))
+	if len(cfg.PriorityWeights) > 0 {
+		queueOpts = append(queueOpts, WithPriorityWeights(cfg.PriorityWeights))
+	}
+
+	q, err := CreateQueue(m.parent, append(queueOpts, opts...)...)
+	if err != nil {
+		return err
+	}
+
+	q.dependencies = m.dependencies
+	q.owner = m.queueOf
+	q.concurrency = m.concurrency
+	q.concurrency.subscribe(q.notify)
+	q.rateLimiter = m.rateLimiter
+	for typeOf := range m.paused {
+		q.PauseTaskType(typeOf)
+	}
+
+	// Report the sizes the queue ended up with
+	cfg.MaxBufferSize = q.maxBufferSize
+	cfg.PriorityLevels = q.priorityLevels
+	cfg.PriorityWeights = q.weights
+	cfg.WorkerPoolSize = q.workerPoolSize
+
+	m.queues[cfg.Name] = &namedQueue{q: q, cfg: cfg, taskChan: taskChan}
+	m.order = append(m.order, cfg.Name)
+	for _, typeOf := range cfg.TaskTypes {
+		m.routes[typeOf] = cfg.Name
+	}
+
+	return nil
+}
+
+// route returns the name of the queue the task is processed by, the caller holds the mutex
+func (m *Manager) route(t *task.Task) string {
+	if t.Queue != "" {
+		if _, ok := m.queues[t.Queue]; ok {
+			return t.Queue
+		}
+		slog.Warn(fmt.Sprintf("task %s was sent to unknown queue %s, routing it by its type", t.Id, t.Queue))
+	}
+
+	if queue, ok := m.routes[t.TaskType]; ok {
+		return queue
+	}
+
+	return DefaultQueue
+}
+
+// group splits the tasks by the queue they are routed to
+func (m *Manager) group(tasks []*task.Task) map[string][]*task.Task {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	byQueue := make(map[string][]*task.Task)
+	for _, t := range tasks {
+		name := m.route(t)
+		byQueue[name] = append(byQueue[name], t)
+	}
+
+	return byQueue
+}
+
+// queueOf returns the queue the task is processed by
+func (m *Manager) queueOf(t *task.Task) *Queue {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return m.queues[m.route(t)].q
+}
+
+func (m *Manager) get(name string) (*namedQueue, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	nq, ok := m.queues[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownQueue, name)
+	}
+
+	return nq, nil
+}
+
+// list returns the queues in the order they were created, the queues are called without holding the mutex
+func (m *Manager) list() []*namedQueue {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	queues := make([]*namedQueue, 0, len(m.order))
+	for _, name := range m.order {
+		queues = append(queues, m.queues[name])
+	}
+
+	return queues
+}
+
+// info describes the queue and its current load
+func (nq *namedQueue) info() QueueInfo {
+	return QueueInfo{
+		QueueConfig: nq.cfg,
+		Workers:     nq.q.WorkerPoolSize(),
+		Backlog:     nq.q.awaitingQueue.len() + len(nq.q.dispatchChan),
+		Paused:      nq.q.Paused(),
+	}
+}
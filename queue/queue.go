@@ -2,6 +2,8 @@ package queue
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"log/slog"
@@ -19,34 +21,63 @@ type option func(q *Queue)
 // Queue represents our queue handler taking care of all the retries, awaits and passing the tasks onto workers
 type Queue struct {
 	ctx            context.Context
+	cancel         context.CancelFunc
 	maxBufferSize  int
+	priorityLevels int
+	weights        []int         // share of the workers per priority level, index 0 is the lowest priority
+	agingThreshold time.Duration // waiting time after which a task is promoted a priority level, 0 disables aging
 	workerPoolSize int
 	maxTaskRetry   int
+	taskTimeout    time.Duration                       // default execution deadline, 0 means no deadline
+	taskTimeouts   map[task.TypeOf]time.Duration       // execution deadline per task type
+	backoffs       map[task.TypeOf]*task.BackoffPolicy // backoff policy per task type
+	concurrency    *concurrency                        // caps how many tasks of a type execute at once
+	rateLimiter    *rateLimiter                        // caps how many tasks of a type are dispatched per second
+	autoscaling    *Autoscaling                        // resizes the worker pool with the backlog, nil keeps a fixed size
 	db             storage.Storage
 
-	mainTaskChan  *chan []*task.Task // we receive any new tasks on this channel
-	resultChan    chan task.Task     // the workers can write the task status back to this channel
-	priorityChans []chan task.Task   // deals with the different priorities low / high
-	workerPool    *WorkerPool        // instance of our workers that are created here, could perhaps be externalised to its own package
+	mainTaskChan *chan []*task.Task // we receive any new tasks on this channel
+	resultChan   chan task.Task     // the workers can write the task status back to this channel
+	dispatchChan chan task.Task     // buffered chan the workers pick tasks from, filled in weighted round robin order
+	workerPool   *WorkerPool        // instance of our workers that are created here, could perhaps be externalised to its own package
 
-	awaitingQueue linkedList // stores tasks when the buffered chans dont have capacity yet
+	awaitingQueue *scheduler                // stores tasks when the buffered chans dont have capacity yet or are backing off
+	wake          chan struct{}             // wakes up the dispatcher when a task is enqueued or a worker picks up a task
+	dependencies  *dependencies             // holds the workflow tasks until the tasks they depend on finish
+	owner         func(t *task.Task) *Queue // queue a released task is processed by, the manager shares dependencies across queues
+
+	mutex          sync.Mutex
+	dispatched     map[string]struct{}      // tasks handed over to the workers whose result has not been settled yet
+	cancelRequests map[string]struct{}      // dispatched tasks which were asked to be cancelled
+	paused         bool                     // nothing is dispatched while paused, the tasks wait in the awaiting queue
+	pausedTypes    map[task.TypeOf]struct{} // task types which are not dispatched while the other types are
+
+	dispatcherDone chan struct{} // closed once pushToProcess has stopped
+	resultsDone    chan struct{} // closed once awaitResults has handled the last result
 }
 
 // CreateQueue creates and returns the Queue with predefined options
 func CreateQueue(ctx context.Context, opts ...option) (*Queue, error) {
 	q := Queue{
-		ctx:            ctx,
 		maxBufferSize:  10,
+		priorityLevels: task.DefaultPriorityLevels,
 		workerPoolSize: 5,
 		maxTaskRetry:   0,
 
-		priorityChans: make([]chan task.Task, 0, 2),
-		resultChan:    make(chan task.Task),
-		awaitingQueue: linkedList{
-			listMutex: sync.Mutex{},
-		},
+		resultChan:     make(chan task.Task),
+		wake:           make(chan struct{}, 1),
+		dependencies:   newDependencies(),
+		dispatched:     make(map[string]struct{}),
+		cancelRequests: make(map[string]struct{}),
+		pausedTypes:    make(map[task.TypeOf]struct{}),
+		concurrency:    newConcurrency(nil),
+		rateLimiter:    newRateLimiter(nil),
+		dispatcherDone: make(chan struct{}),
+		resultsDone:    make(chan struct{}),
 	}
 
+	q.ctx, q.cancel = context.WithCancel(ctx)
+
 	for _, opt := range opts {
 		opt(&q)
 	}
@@ -55,11 +86,22 @@ func CreateQueue(ctx context.Context, opts ...option) (*Queue, error) {
 		return nil, fmt.Errorf("main task channel must be set")
 	}
 
-	for i := 1; i <= 2; i++ {
-		q.priorityChans = append(q.priorityChans, make(chan task.Task, q.maxBufferSize))
+	if len(q.weights) > q.priorityLevels {
+		return nil, fmt.Errorf("%d priority weights set for %d priority levels", len(q.weights), q.priorityLevels)
+	}
+
+	// Levels without a configured weight default to their level so higher priorities get a bigger share
+	for level := len(q.weights); level < q.priorityLevels; level++ {
+		q.weights = append(q.weights, level+1)
 	}
 
-	q.workerPool = CreateWorkerPool(q.workerPoolSize, q.resultChan, q.priorityChans)
+	q.owner = func(*task.Task) *Queue { return &q }
+	q.concurrency.subscribe(q.notify)
+
+	q.awaitingQueue = newScheduler(q.weights, q.agingThreshold)
+	q.dispatchChan = make(chan task.Task, q.maxBufferSize)
+
+	q.workerPool = CreateWorkerPool(q.ctx, q.workerPoolSize, q.resultChan, q.dispatchChan, q.notify)
 
 	return &q, nil
 }
@@ -74,7 +116,7 @@ func WithMainQueue(taskChan *chan []*task.Task) option {
 	}
 }
 
-// WithMaxBufferSize the max queue size before it must be processed to avoid starvation
+// WithMaxBufferSize the amount of tasks dispatched ahead of the workers picking them up
 func WithMaxBufferSize(size int) option {
 	return func(q *Queue) {
 		if size > 1 {
@@ -83,6 +125,37 @@ func WithMaxBufferSize(size int) option {
 	}
 }
 
+// WithPriorityLevels the amount of priority levels, priorities range from 0 to levels - 1
+func WithPriorityLevels(levels int) option {
+	return func(q *Queue) {
+		if levels >= 1 {
+			q.priorityLevels = levels
+		}
+	}
+}
+
+// WithPriorityWeights the share of workers each priority level gets, starting from the lowest priority.
+// A level with weight 3 dispatches 3 tasks per round for every task of a level with weight 1, weights below 1 are
+// raised to 1 so no level can be starved
+func WithPriorityWeights(weights []int) option {
+	return func(q *Queue) {
+		q.weights = make([]int, len(weights))
+		for level, weight := range weights {
+			q.weights[level] = max(weight, 1)
+		}
+	}
+}
+
+// WithAgingThreshold promotes a waiting task to the next priority level every time it waited the threshold,
+// preventing low priority tasks from starving when the higher levels are busy
+func WithAgingThreshold(threshold time.Duration) option {
+	return func(q *Queue) {
+		if threshold > 0 {
+			q.agingThreshold = threshold
+		}
+	}
+}
+
 // WithMaxWorkerPoolSize the amount of workers in the worker pool
 func WithMaxWorkerPoolSize(size int) option {
 	return func(q *Queue) {
@@ -101,6 +174,67 @@ func WithMaxTaskRetry(retries int) option {
 	}
 }
 
+// WithTaskTimeout sets the default execution deadline of a task when neither the request nor its type set one
+func WithTaskTimeout(timeout time.Duration) option {
+	return func(q *Queue) {
+		if timeout > 0 {
+			q.taskTimeout = timeout
+		}
+	}
+}
+
+// WithTaskTimeouts sets the execution deadline per task type, the request can still override it
+func WithTaskTimeouts(timeouts map[task.TypeOf]time.Duration) option {
+	return func(q *Queue) {
+		q.taskTimeouts = timeouts
+	}
+}
+
+// WithBackoffPolicies sets the backoff policy per task type, used when the request sets neither a policy
+// nor a backoff duration
+func WithBackoffPolicies(policies map[task.TypeOf]*task.BackoffPolicy) option {
+	return func(q *Queue) {
+		q.backoffs = policies
+	}
+}
+
+// WithConcurrencyLimits sets the max amount of tasks of a type executing at once, tasks over the limit wait in the
+// awaiting queue while the other types keep being dispatched. Limits below 1 are ignored
+func WithConcurrencyLimits(limits map[task.TypeOf]int) option {
+	return func(q *Queue) {
+		q.concurrency = newConcurrency(limits)
+	}
+}
+
+// WithRateLimits sets the rate limit per task type, tasks over the limit are deferred in the awaiting queue with
+// the rate limited status until their token is refilled. Limits without a positive rate are ignored
+func WithRateLimits(limits map[task.TypeOf]RateLimit) option {
+	return func(q *Queue) {
+		q.rateLimiter = newRateLimiter(limits)
+	}
+}
+
+// WithAutoscaling grows and shrinks the worker pool between the min and max workers based on the backlog and the
+// time tasks wait for a worker, nil keeps the pool at a fixed size. The interval defaults to 5s and the step to 1 worker
+func WithAutoscaling(autoscaling *Autoscaling) option {
+	return func(q *Queue) {
+		if autoscaling == nil {
+			return
+		}
+
+		cfg := *autoscaling
+		if cfg.MinWorkers < 1 || cfg.MaxWorkers < cfg.MinWorkers {
+			log.Fatalf("autoscaling needs at least 1 min worker and max workers above the min, got %d and %d",
+				cfg.MinWorkers, cfg.MaxWorkers)
+		}
+		if cfg.Interval <= 0 {
+			cfg.Interval = 5 * time.Second
+		}
+		cfg.Step = max(cfg.Step, 1)
+		q.autoscaling = &cfg
+	}
+}
+
 // WithStorage adds persistent data store
 func WithStorage(storage storage.Storage) option {
 	return func(q *Queue) {
@@ -109,57 +243,484 @@ func WithStorage(storage storage.Storage) option {
 }
 
 // Start the queue starts listening to new tasks coming in
-func (q *Queue) Start() {
+// unfinished tasks left over from a previous run are recovered from storage before any new task is accepted
+func (q *Queue) Start() error {
+	tasks, err := q.db.GetUnfinishedTasks()
+	if err != nil {
+		return fmt.Errorf("failed to load unfinished tasks: %v", err)
+	}
+
+	return q.start(tasks)
+}
+
+// start recovers the unfinished tasks and starts listening to new tasks coming in
+func (q *Queue) start(unfinished []*task.Task) error {
+	if err := q.recover(unfinished); err != nil {
+		return fmt.Errorf("failed to recover unfinished tasks: %v", err)
+	}
+
 	go q.awaitTasks()
 	go q.awaitResults()
 	go q.pushToProcess()
+
+	if q.autoscaling != nil {
+		go (&autoscaler{q: q, cfg: *q.autoscaling}).run()
+	}
+
+	return nil
+}
+
+// recover reloads the tasks which were not finished when the process last stopped and re-enqueues them
+// with their retry count and backoff preserved
+func (q *Queue) recover(tasks []*task.Task) error {
+	recovered := make([]*task.Task, 0, len(tasks))
+	for _, t := range tasks {
+		// The storage layer rehydrates the task through the task registry, without an implementation
+		// the task can never be processed so it is failed straight away
+		if t.ProcessableTask == nil {
+			t.ErrorDetails = fmt.Sprintf("unsupported task type: %s", t.TaskType)
+			slog.Error(fmt.Sprintf("failed to recover task %s: %s \n", t.Id, t.ErrorDetails))
+			q.fail(t)
+			continue
+		}
+
+		// Restore the attempt history so the numbering carries on and a dead letter keeps every attempt
+		attempts, err := q.db.GetTaskAttempts(t.Id)
+		if err != nil {
+			return fmt.Errorf("failed to load attempts of task %s: %v", t.Id, err)
+		}
+		t.Attempts = attempts
+
+		// A task which was being processed was interrupted, it is picked up again without using up a retry.
+		// A scheduled task keeps its status and run time until it is due, a blocked one waits for its dependencies
+		if t.Status != task.ProcessingScheduled && t.Status != task.ProcessingBlocked {
+			t.Status = task.ProcessingAwaiting
+		}
+		t.StartedAt = nil
+		t.Error = nil
+		if err := q.db.UpdateTask(t); err != nil {
+			slog.Error(fmt.Sprintf("failed to update task details to database: %v \n", err))
+		}
+
+		recovered = append(recovered, t)
+	}
+
+	q.enqueue(recovered...)
+	slog.Info(fmt.Sprintf("recovered %d unfinished tasks from storage", len(recovered)))
+
+	return nil
+}
+
+// Shutdown stops the queue from picking up and dispatching tasks, waits for the tasks in flight to finish until
+// the context is done and persists every task that has not been processed back to storage as awaiting
+func (q *Queue) Shutdown(ctx context.Context) {
+	slog.Info("queue shutdown initiated, draining tasks")
+	q.cancel()
+
+	<-q.dispatcherDone
+	q.workerPool.Shutdown(ctx)
+
+	// Every worker has stopped so nothing writes to the result chan anymore
+	close(q.resultChan)
+	<-q.resultsDone
+
+	q.persistAwaiting()
+	slog.Info("queue shutdown complete")
 }
 
-// pushToProcess scans the current queue and pushes to the workers when there is space in the buffered chans
-// and a tasks backoff is finished or nil
+// persistAwaiting drains the dispatch chan and the awaiting queue and saves the tasks as awaiting
+// so that they are not lost when the process exits
+func (q *Queue) persistAwaiting() {
+	tasks := make([]*task.Task, 0)
+
+drain:
+	for {
+		select {
+		case t := <-q.dispatchChan:
+			tasks = append(tasks, &t)
+		default:
+			break drain
+		}
+	}
+
+	tasks = append(tasks, q.awaitingQueue.drain()...)
+
+	for _, t := range tasks {
+		// A task cancelled while waiting on the dispatch chan must not be brought back by the next start
+		if q.takeCancelRequest(t.Id) {
+			q.markCancelled(t)
+			continue
+		}
+
+		if t.Status != task.ProcessingScheduled {
+			t.Status = task.ProcessingAwaiting
+		}
+		if err := q.db.UpdateTask(t); err != nil {
+			slog.Error(fmt.Sprintf("failed to persist awaiting task %s on shutdown: %v \n", t.Id, err))
+		}
+	}
+
+	slog.Info(fmt.Sprintf("persisted %d awaiting tasks on shutdown", len(tasks)))
+}
+
+// pushToProcess dispatches tasks from the awaiting queue to the workers whenever there is space in the buffered chan
+// and a tasks backoff is finished or nil. It sleeps until a task is enqueued, a worker frees up space
+// or the earliest backoff expires
 func (q *Queue) pushToProcess() {
+	defer close(q.dispatcherDone)
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
 	for {
-		// TODO add context deadline
-
-		// Check if any of the chans have space for new tasks starting from highest priority one
-		for priorityId := len(q.priorityChans) - 1; priorityId >= 0; priorityId-- {
-			space := q.maxBufferSize - len(q.priorityChans[priorityId])
-
-			if space > 0 {
-				if q.awaitingQueue.getFirst() != nil {
-					currNode := q.awaitingQueue.first
-					for currNode != nil && space >= 0 {
-						// Small hack but with having more time I would probably rewrite priorityChans to be a map
-						// of taskId:taskPriority to allow for better prioritisation
-						if int(currNode.t.Priority) == priorityId && (currNode.t.BackOffUntil == nil ||
-							(currNode.t.BackOffUntil != nil && currNode.t.BackOffUntil.Before(time.Now()))) {
-
-							// Enqueue the task to channel to be picked up by worker
-							currNode.t.Status = task.ProcessingEnqueued
-							slog.Info(fmt.Sprintf("enqueing task %s", currNode.t.Id))
-							q.priorityChans[priorityId] <- *currNode.t
-							q.awaitingQueue.pop(currNode)
-
-							err := q.db.UpdateTask(currNode.t)
-							if err != nil {
-								slog.Error(fmt.Sprintf("failed to update task details to database: %v \n", err))
-							}
-
-							// Decrease current available space on the chan and pop the node from the awaiting queue
-							space--
-						}
-						currNode = currNode.next
-					}
-				}
+		q.dispatch()
+
+		// Wake up exactly when the next backed off task becomes eligible
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
 			}
 		}
+		if due, ok := q.awaitingQueue.nextDue(); ok {
+			timer.Reset(time.Until(due))
+		}
+
+		select {
+		case <-q.ctx.Done():
+			slog.Info("dispatcher stopped, queue context cancelled")
+			return
+		case <-q.wake:
+		case <-timer.C:
+		}
+	}
+}
+
+// dispatch pushes the ready tasks onto the dispatch chan in weighted round robin order until the chan is full.
+// The dispatcher is the only writer so the send never blocks, a small buffer keeps the order closer to the weights
+func (q *Queue) dispatch() {
+	q.awaitingQueue.promoteDue(time.Now().UTC())
+	q.withdrawPaused()
+
+	for len(q.dispatchChan) < cap(q.dispatchChan) {
+		// Popping and marking as dispatched happen together so a cancellation always finds the task in one of them
+		q.mutex.Lock()
+		var t *task.Task
+		if !q.paused {
+			// The slot is taken while the limits are checked as the other queues of a manager share them
+			q.concurrency.mutex.Lock()
+			if t = q.awaitingQueue.popReady(q.dispatchable); t != nil {
+				q.concurrency.acquire(t.TaskType)
+			}
+			q.concurrency.mutex.Unlock()
+		}
+		cancelled, deferred := false, false
+		if t != nil {
+			if cancelled, deferred = q.prepareDispatch(t); cancelled || deferred {
+				q.concurrency.release(t.TaskType)
+			} else {
+				q.dispatched[t.Id] = struct{}{}
+			}
+		}
+		q.mutex.Unlock()
+
+		if t == nil {
+			return
+		}
+
+		if cancelled {
+			// A task awaiting its retry is still marked as dispatched from its previous attempt
+			q.settle(t.Id)
+			slog.Info(fmt.Sprintf("task: %s cancelled before reaching the queue \n", t.Id))
+			if err := q.markCancelled(t); err != nil {
+				slog.Error(fmt.Sprintf("failed to cancel task %s: %v", t.Id, err))
+			}
+			continue
+		}
+
+		if deferred {
+			continue
+		}
+
+		// Enqueue the task to channel to be picked up by worker
+		slog.Info(fmt.Sprintf("enqueing task %s", t.Id))
+		q.dispatchChan <- *t
+	}
+}
+
+// prepareDispatch saves the status the task leaves the awaiting queue with, it is deferred until its type has a
+// token for it or enqueued for a worker. A task coming back from being rate limited already reserved its token.
+// The caller holds the mutex so the status is saved before a cancellation can find the task again. A task cancelled
+// before the queue received it is only cancelled in storage, the update leaves it cancelled and it is reported back
+func (q *Queue) prepareDispatch(t *task.Task) (cancelled bool, deferred bool) {
+	now := time.Now().UTC()
+
+	var wait time.Duration
+	if t.Status != task.ProcessingRateLimited {
+		wait = q.rateLimiter.reserve(t, now)
+	}
+
+	t.Status = task.ProcessingEnqueued
+	if wait > 0 {
+		t.Status = task.ProcessingRateLimited
+		slog.Info(fmt.Sprintf("task %s rate limited for %v", t.Id, wait))
+	}
+
+	if err := q.db.UpdateTaskUnlessCancelled(t); err != nil {
+		if errors.Is(err, storage.ErrTaskCancelled) {
+			return true, false
+		}
+		slog.Error(fmt.Sprintf("failed to update task details to database: %v \n", err))
+	}
+
+	if wait > 0 {
+		q.awaitingQueue.pushDelayed(t, now.Add(wait))
+		return false, true
+	}
+
+	return false, false
+}
+
+// dispatchable reports whether the task can be dispatched now, its type is neither paused nor at its limit.
+// The caller holds the mutex and the concurrency mutex
+func (q *Queue) dispatchable(t *task.Task) bool {
+	if _, paused := q.pausedTypes[t.TaskType]; paused {
+		return false
+	}
+
+	return q.concurrency.available(t.TaskType)
+}
+
+// withdrawPaused moves the paused tasks which were dispatched but not picked up by a worker yet back to the awaiting
+// queue. The dispatcher is the only writer to the dispatch chan so the tasks it keeps always fit back on it
+func (q *Queue) withdrawPaused() {
+	q.mutex.Lock()
+	if !q.paused && len(q.pausedTypes) == 0 {
+		q.mutex.Unlock()
+		return
+	}
+
+	now := time.Now().UTC()
+	keep := make([]task.Task, 0, len(q.dispatchChan))
+	cancelled := make([]*task.Task, 0)
+	for pending := len(q.dispatchChan); pending > 0; pending-- {
+		var t task.Task
+		select {
+		case t = <-q.dispatchChan:
+		default:
+			pending = 0
+			continue
+		}
+
+		if _, pausedType := q.pausedTypes[t.TaskType]; !q.paused && !pausedType {
+			keep = append(keep, t)
+			continue
+		}
+
+		delete(q.dispatched, t.Id)
+		q.concurrency.release(t.TaskType)
+
+		// A cancellation which came in while the task was on the chan is settled here instead of by a worker
+		if _, ok := q.cancelRequests[t.Id]; ok {
+			delete(q.cancelRequests, t.Id)
+			cancelled = append(cancelled, &t)
+			continue
+		}
+
+		// Back to awaiting so the task reserves a fresh rate limit token instead of relying on an earlier one once
+		// it is dispatched again, a task cancelled in storage in the meantime stays cancelled
+		t.Status = task.ProcessingAwaiting
+		if err := q.db.UpdateTaskUnlessCancelled(&t); err != nil {
+			if errors.Is(err, storage.ErrTaskCancelled) {
+				cancelled = append(cancelled, &t)
+				continue
+			}
+			slog.Error(fmt.Sprintf("failed to update task details to database: %v \n", err))
+		}
+
+		slog.Info(fmt.Sprintf("task %s withdrawn from dispatch, paused", t.Id))
+		q.awaitingQueue.push(&t, now)
+	}
+
+	for _, t := range keep {
+		q.dispatchChan <- t
+	}
+	q.mutex.Unlock()
+
+	for _, t := range cancelled {
+		q.workerPool.forget(t.Id)
+		if err := q.markCancelled(t); err != nil {
+			slog.Error(fmt.Sprintf("failed to cancel withdrawn task %s: %v", t.Id, err))
+		}
+	}
+}
+
+// executed frees the slot of a task whose result came back, a type at its limit can be dispatched again
+func (q *Queue) executed(typeOf task.TypeOf) {
+	q.concurrency.release(typeOf)
+}
+
+// notify wakes up the dispatcher without blocking, a pending wake up already covers any new changes
+func (q *Queue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
 	}
 }
 
 // enqueue adds tasks to the awaiting channel queue to be processed when a worker is available
 func (q *Queue) enqueue(tasks ...*task.Task) {
+	now := time.Now().UTC()
 	for _, t := range tasks {
-		q.awaitingQueue.append(t)
+		q.resolveTimeout(t)
+		q.resolveBackoff(t)
+
+		if t.Status == task.ProcessingBlocked {
+			q.block(t)
+			continue
+		}
+
+		q.awaitingQueue.push(t, now)
+	}
+	q.notify()
+}
+
+// block holds the task until its dependencies finish, a task whose dependencies already finished is released
+// or skipped right away
+func (q *Queue) block(t *task.Task) {
+	blocked, succeeded := q.dependencies.add(t, q.storedStatus)
+	switch {
+	case blocked:
+		slog.Info(fmt.Sprintf("task %s blocked until its dependencies succeed", t.Id))
+	case succeeded:
+		q.release(t)
+	default:
+		// Tasks which were blocked on this one before it was skipped are skipped with it
+		q.skip(t, "a dependency did not succeed")
+		q.finished(t)
+	}
+}
+
+// storedStatus returns the stored status of the task, empty when it can't be found
+func (q *Queue) storedStatus(id string) task.CurrentStatus {
+	t, err := q.db.GetTaskById(id)
+	if err != nil || t == nil {
+		slog.Error(fmt.Sprintf("failed to find dependency %s: %v", id, err))
+		return ""
+	}
+	return t.Status
+}
+
+// finished releases or skips the tasks depending on the task once it reached a final status
+func (q *Queue) finished(t *task.Task) {
+	released, skipped := q.dependencies.resolve(t.Id, t.Status == task.ProcessingSuccess)
+
+	for _, s := range skipped {
+		q.skip(s, fmt.Sprintf("dependency %s did not succeed", t.Id))
+	}
+
+	for _, r := range released {
+		q.owner(r).release(r)
+	}
+}
+
+// release hands a task whose dependencies all succeeded over to the scheduler
+func (q *Queue) release(t *task.Task) {
+	// A payload which can't be rendered from the results won't render on a retry either
+	if err := q.renderPayload(t); err != nil {
+		t.ErrorDetails = err.Error()
+		slog.Error(fmt.Sprintf("failed to render payload of task %s: %v \n", t.Id, err))
+		q.fail(t)
+		return
+	}
+
+	now := time.Now().UTC()
+
+	t.Status = task.ProcessingAwaiting
+	if t.BackOffUntil != nil && t.BackOffUntil.After(now) {
+		t.Status = task.ProcessingScheduled
+	}
+
+	// A task cancelled before the queue received it is only cancelled in storage, it stays cancelled
+	err := q.db.UpdateTaskUnlessCancelled(t)
+	if errors.Is(err, storage.ErrTaskCancelled) {
+		slog.Info(fmt.Sprintf("task: %s cancelled before its dependencies succeeded \n", t.Id))
+		if err := q.markCancelled(t); err != nil {
+			slog.Error(fmt.Sprintf("failed to cancel task %s: %v", t.Id, err))
+		}
+		return
+	}
+	// The rendered payload can make the task the same work as a task which is already pending
+	if errors.Is(err, storage.ErrUniqueTaskPending) {
+		t.ErrorDetails = fmt.Sprintf("a task with unique key %s is already pending", t.UniqueKey)
+		slog.Error(fmt.Sprintf("failed to release task %s: %s", t.Id, t.ErrorDetails))
+		q.fail(t)
+		return
+	}
+	if err != nil {
+		slog.Error(fmt.Sprintf("failed to update task details to database: %v \n", err))
+	}
+
+	slog.Info(fmt.Sprintf("task %s released, its dependencies succeeded", t.Id))
+	q.awaitingQueue.push(t, now)
+	q.notify()
+}
+
+// renderPayload renders the payload of the task with the results of its dependencies
+func (q *Queue) renderPayload(t *task.Task) error {
+	results := make(map[string]json.RawMessage, len(t.DependsOn))
+	for _, id := range t.DependsOn {
+		dependency, err := q.db.GetTaskById(id)
+		if err != nil {
+			return fmt.Errorf("failed to load dependency %s: %v", id, err)
+		}
+
+		if dependency.Key != "" && len(dependency.Result) > 0 {
+			results[dependency.Key] = dependency.Result
+		}
+	}
+
+	return t.RenderPayload(results)
+}
+
+// skip saves the skipped status of a task which can never run because a dependency did not succeed
+func (q *Queue) skip(t *task.Task, reason string) {
+	t.Status = task.ProcessingSkipped
+	currTime := time.Now().UTC()
+	t.FinishedAt = &currTime
+	t.ErrorDetails = reason
+
+	slog.Warn(fmt.Sprintf("task %s skipped: %s", t.Id, reason))
+	if err := q.db.UpdateTask(t); err != nil {
+		slog.Error(fmt.Sprintf("failed to update task details to database: %v \n", err))
+	}
+}
+
+// resolveTimeout sets the execution deadline from the task type or queue default unless the request set one
+func (q *Queue) resolveTimeout(t *task.Task) {
+	if t.Timeout != nil {
+		return
+	}
+
+	if timeout, ok := q.taskTimeouts[t.TaskType]; ok && timeout > 0 {
+		t.Timeout = &timeout
+		return
+	}
+
+	if q.taskTimeout > 0 {
+		timeout := q.taskTimeout
+		t.Timeout = &timeout
+	}
+}
+
+// resolveBackoff sets the backoff policy of the task type unless the request set its own backoff
+func (q *Queue) resolveBackoff(t *task.Task) {
+	if t.BackOffPolicy != nil || t.BackOffDuration != nil {
+		return
+	}
+
+	if policy, ok := q.backoffs[t.TaskType]; ok {
+		t.BackOffPolicy = policy
 	}
 }
 
@@ -181,125 +742,277 @@ func (q *Queue) awaitTasks() {
 	}
 }
 
-// awaitResults waiting for task results so that it can retry or fail them
-func (q *Queue) awaitResults() {
-	slog.Info("await results queue has started listening")
-	for {
-		select {
-		case <-q.ctx.Done():
-			slog.Info("queue shutdown initiated, main context cancelled")
-			return
-		case t, ok := <-q.resultChan:
-			if !ok {
-				slog.Error("reading from empty channel")
-				return
-			}
+// fail saves the failed status of the task and moves it to the dead letter queue
+func (q *Queue) fail(t *task.Task) {
+	t.Status = task.ProcessingFailed
+	currTime := time.Now().UTC()
+	t.FinishedAt = &currTime
 
-			if t.Error != nil {
-				if t.Retries >= q.maxTaskRetry {
-					t.Status = task.ProcessingFailed
-					fmt.Println(t.ErrorDetails)
-					slog.Error(fmt.Sprintf("error while processing task: %s no retries left saving failed status, error: %v \n", t.Id, t.Error))
-					err := q.db.UpdateTask(&t)
-					if err != nil {
-						slog.Error(fmt.Sprintf("failed to update task details to database: %v \n", err))
-					}
-					continue
-				}
+	if err := q.db.UpdateTask(t); err != nil {
+		slog.Error(fmt.Sprintf("failed to update task details to database: %v \n", err))
+	}
 
-				t.Retries++
-				if t.BackOffDuration != nil {
-					bckOffUntil := time.Now().Add(*t.BackOffDuration)
-					t.BackOffUntil = &bckOffUntil
-				}
-				slog.Info(fmt.Sprintf("failed: error while processing task: %s, retrying. retry attempt:%d error: %v \n", t.Id, t.Retries, t.Error))
+	if err := q.db.CreateDeadLetter(storage.NewDeadLetter(t)); err != nil {
+		slog.Error(fmt.Sprintf("failed to move task %s to the dead letter queue: %v \n", t.Id, err))
+	}
 
-				t.Error = nil
-				q.enqueue(&t)
-				continue
-			}
+	q.finished(t)
+}
 
-			t.Status = task.ProcessingSuccess
-			currTime := time.Now().UTC()
-			t.FinishedAt = &currTime
-			err := q.db.UpdateTask(&t)
-			if err != nil {
-				slog.Error(fmt.Sprintf("failed to update task details to database: %v \n", err))
-			}
-			slog.Info(fmt.Sprintf("task:%s processed succesfully \n", t.Id))
-		}
+// CancelTask cancels a task held by the queue. A task which has not been dispatched yet is removed and saved as
+// cancelled straight away, a dispatched task is cancelled cooperatively through the context of the worker and
+// saved once its result comes back. Returns false when the queue does not hold the task
+func (q *Queue) CancelTask(id string) (bool, error) {
+	if t := q.dependencies.remove(id); t != nil {
+		slog.Info(fmt.Sprintf("task: %s cancelled while blocked by its dependencies \n", id))
+		return true, q.markCancelled(t)
 	}
-}
 
-// I thought a linked list would be good to keep track of execution
-// This is due to channels in Go having to be buffered in order to keep items on their queue
-// A unbuffered channel does not wait until we have a worker available to read it
-// A buffered channel can only hold X tasks but will hold those until the workers pick them up which leaves us with
-// in-between tasks that are not ready for the channels but still need to be scheduled
-// In addition I wanted to have somewhere to store the tasks with backoff so they can be picked up later
-// (editing an array mid iteration is not a good idea so thought linked list might work better here)
-type linkedList struct {
-	listMutex sync.Mutex
-	first     *node
-	last      *node
-}
+	q.mutex.Lock()
+	t := q.awaitingQueue.remove(id)
+	_, inFlight := q.dispatched[id]
+	if t == nil && inFlight {
+		q.cancelRequests[id] = struct{}{}
+	}
+	q.mutex.Unlock()
 
-type node struct {
-	t    *task.Task
-	next *node
-	prev *node
-}
+	if t != nil {
+		// A task awaiting its retry is still marked as dispatched from its previous attempt
+		q.settle(id)
+		slog.Info(fmt.Sprintf("task: %s cancelled before being dispatched \n", id))
+		return true, q.markCancelled(t)
+	}
+
+	if !inFlight {
+		return false, nil
+	}
 
-func (l *linkedList) getFirst() *task.Task {
-	// TODO https://go.dev/doc/articles/race_detector#Runtime_Overheads
-	l.listMutex.Lock()
-	defer l.listMutex.Unlock()
+	q.workerPool.Cancel(id)
 
-	if l.first == nil {
-		return nil
+	// Persist the cancellation right away so the task is not recovered if the process stops before the worker returns
+	if err := q.db.UpdateTaskStatus(id, task.ProcessingCancelled); err != nil {
+		return true, fmt.Errorf("failed to save cancelled status of task %s: %v", id, err)
 	}
 
-	return l.first.t
+	return true, nil
 }
 
-func (l *linkedList) append(t *task.Task) {
-	l.listMutex.Lock()
-	defer l.listMutex.Unlock()
+// PendingUniqueTask returns the id of the task of the type with the unique key which awaits processing or its
+// dependencies in memory. Tasks handed over to the workers are only found through storage
+func (q *Queue) PendingUniqueTask(taskType task.TypeOf, uniqueKey string) (string, bool) {
+	if t := q.awaitingQueue.findUnique(taskType, uniqueKey); t != nil {
+		return t.Id, true
+	}
 
-	node := &node{
-		t:    t,
-		next: nil,
-		prev: nil,
+	if t := q.dependencies.findUnique(taskType, uniqueKey); t != nil {
+		return t.Id, true
 	}
 
-	if l.first == nil {
-		l.first = node
-		l.last = node
-		return
+	return "", false
+}
+
+// ResizeWorkerPool grows or shrinks the worker pool at runtime, retired workers finish their task in flight first
+func (q *Queue) ResizeWorkerPool(size int) error {
+	if err := q.workerPool.Resize(size); err != nil {
+		return err
 	}
 
-	l.last.next = node
-	node.prev = l.last
-	l.last = node
+	// New workers can take tasks the dispatcher could not fit on the chan before
+	q.notify()
+
+	return nil
 }
 
-func (l *linkedList) pop(n *node) {
-	l.listMutex.Lock()
-	defer l.listMutex.Unlock()
+// WorkerPoolSize returns the amount of workers picking up tasks
+func (q *Queue) WorkerPoolSize() int {
+	return q.workerPool.Size()
+}
+
+// Pause stops dispatching tasks, the tasks in flight finish and the others wait in the awaiting queue until resumed
+func (q *Queue) Pause() {
+	q.mutex.Lock()
+	q.paused = true
+	q.mutex.Unlock()
+
+	// The dispatcher withdraws the tasks the workers have not picked up yet
+	q.notify()
+}
+
+// Resume dispatches the tasks again
+func (q *Queue) Resume() {
+	q.mutex.Lock()
+	q.paused = false
+	q.mutex.Unlock()
+
+	q.notify()
+}
+
+// Paused reports whether the queue is paused
+func (q *Queue) Paused() bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return q.paused
+}
 
-	if l.first == n {
-		l.first = n.next
+// PauseTaskType stops dispatching tasks of the type while the other types keep being dispatched
+func (q *Queue) PauseTaskType(typeOf task.TypeOf) {
+	q.mutex.Lock()
+	q.pausedTypes[typeOf] = struct{}{}
+	q.mutex.Unlock()
+
+	q.notify()
+}
+
+// ResumeTaskType dispatches tasks of the type again
+func (q *Queue) ResumeTaskType(typeOf task.TypeOf) {
+	q.mutex.Lock()
+	delete(q.pausedTypes, typeOf)
+	q.mutex.Unlock()
+
+	q.notify()
+}
+
+// backlog returns the amount of ready tasks which can be dispatched and how long the oldest of them has waited,
+// paused tasks are left out as more workers would not process them
+func (q *Queue) backlog(now time.Time) (int, time.Duration) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.paused {
+		return 0, 0
 	}
 
-	if l.last == n {
-		l.last = n.prev
+	return q.awaitingQueue.readyStats(now, func(t *task.Task) bool {
+		_, paused := q.pausedTypes[t.TaskType]
+		return !paused
+	})
+}
+
+// markCancelled saves the cancelled status of the task
+func (q *Queue) markCancelled(t *task.Task) error {
+	t.Status = task.ProcessingCancelled
+	currTime := time.Now().UTC()
+	t.FinishedAt = &currTime
+	t.ErrorDetails = ""
+	t.Error = nil
+
+	err := q.db.UpdateTask(t)
+	if err != nil {
+		slog.Error(fmt.Sprintf("failed to update task details to database: %v \n", err))
 	}
 
-	if n.prev != nil {
-		n.prev.next = n.next
+	q.finished(t)
+
+	return err
+}
+
+// takeCancelRequest reports whether the task was asked to be cancelled and clears the request
+func (q *Queue) takeCancelRequest(id string) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if _, ok := q.cancelRequests[id]; ok {
+		delete(q.cancelRequests, id)
+		return true
 	}
 
-	if n.next != nil {
-		n.next.prev = n.prev
+	return false
+}
+
+// settle forgets a dispatched task once it reached a final status, a cancellation which came in too late is dropped
+func (q *Queue) settle(id string) {
+	q.mutex.Lock()
+	delete(q.dispatched, id)
+	delete(q.cancelRequests, id)
+	q.mutex.Unlock()
+
+	q.workerPool.forget(id)
+}
+
+// awaitResults waiting for task results so that it can retry or fail them
+// it keeps running during shutdown until the result chan is closed so no result of a task in flight is lost
+func (q *Queue) awaitResults() {
+	defer close(q.resultsDone)
+
+	slog.Info("await results queue has started listening")
+	for t := range q.resultChan {
+		q.executed(t.TaskType)
+
+		// A task cancelled before it was picked up was never processed so there is no new attempt to save
+		if t.Status != task.ProcessingCancelled && len(t.Attempts) > 0 {
+			if err := q.db.CreateAttempt(t.Id, t.Attempts[len(t.Attempts)-1]); err != nil {
+				slog.Error(fmt.Sprintf("failed to save attempt of task %s to database: %v \n", t.Id, err))
+			}
+		}
+
+		// The error of a task which was asked to be cancelled is the cancellation, it is not retried
+		if t.Error != nil && q.takeCancelRequest(t.Id) {
+			slog.Info(fmt.Sprintf("task: %s cancelled \n", t.Id))
+			q.markCancelled(&t)
+			q.settle(t.Id)
+			continue
+		}
+
+		// Tasks interrupted by the shutdown grace period did not fail on their own, put them back without
+		// using up a retry so they get persisted as awaiting
+		if t.Error != nil && errors.Is(t.Error, context.Canceled) && q.ctx.Err() != nil {
+			slog.Warn(fmt.Sprintf("task: %s interrupted by shutdown, returning to awaiting queue \n", t.Id))
+			t.Error = nil
+			t.ErrorDetails = ""
+			q.awaitingQueue.push(&t, time.Now().UTC())
+			continue
+		}
+
+		if t.Error != nil {
+			maxRetries := q.maxTaskRetry
+			if t.MaxRetries != nil {
+				maxRetries = *t.MaxRetries
+			}
+
+			if task.IsPermanent(t.Error) || t.Retries >= maxRetries {
+				if task.IsPermanent(t.Error) {
+					slog.Error(fmt.Sprintf("error while processing task: %s is not retryable saving failed status, error: %v \n", t.Id, t.Error))
+				} else {
+					slog.Error(fmt.Sprintf("error while processing task: %s no retries left saving failed status, error: %v \n", t.Id, t.Error))
+				}
+				q.fail(&t)
+				q.settle(t.Id)
+				continue
+			}
+
+			t.Retries++
+			if wait, ok := t.NextBackOff(); ok {
+				bckOffUntil := time.Now().UTC().Add(wait)
+				t.BackOffUntil = &bckOffUntil
+			}
+			if t.Status == task.ProcessingTimedOut {
+				slog.Warn(fmt.Sprintf("timed out: task: %s exceeded its deadline, retrying. retry attempt:%d \n", t.Id, t.Retries))
+			} else {
+				slog.Info(fmt.Sprintf("failed: error while processing task: %s, retrying. retry attempt:%d error: %v \n", t.Id, t.Retries, t.Error))
+			}
+
+			// Persist the retry status so a timed out task is distinguishable while awaiting its retry
+			if err := q.db.UpdateTask(&t); err != nil {
+				slog.Error(fmt.Sprintf("failed to update task details to database: %v \n", err))
+			}
+
+			t.Error = nil
+			q.enqueue(&t)
+			continue
+		}
+
+		t.Status = task.ProcessingSuccess
+		currTime := time.Now().UTC()
+		t.FinishedAt = &currTime
+		err := q.db.UpdateTask(&t)
+		if err != nil {
+			slog.Error(fmt.Sprintf("failed to update task details to database: %v \n", err))
+		}
+		slog.Info(fmt.Sprintf("task:%s processed succesfully \n", t.Id))
+		q.settle(t.Id)
+		q.finished(&t)
 	}
+
+	slog.Info("await results stopped, result channel closed")
 }
@@ -0,0 +1,82 @@
+package queue
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sinderpl/AsyncTaskProcessor/task"
+)
+
+// Package queue/rateLimiter deals with capping how many tasks of a type are dispatched per second
+
+// RateLimit allows Rate tasks per second with bursts of up to Burst tasks, PerUser gives every user of the type
+// their own bucket
+type RateLimit struct {
+	Rate    float64
+	Burst   int
+	PerUser bool
+}
+
+// bucket is a token bucket, tokens go below zero when tasks reserve tokens which have not been refilled yet
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter holds a token bucket per task type, or per task type and user
+type rateLimiter struct {
+	mutex   sync.Mutex
+	limits  map[task.TypeOf]RateLimit
+	buckets map[string]*bucket
+}
+
+// newRateLimiter keeps the limits with a positive rate, the burst is at least 1
+func newRateLimiter(limits map[task.TypeOf]RateLimit) *rateLimiter {
+	valid := make(map[task.TypeOf]RateLimit, len(limits))
+	for typeOf, limit := range limits {
+		if limit.Rate > 0 {
+			limit.Burst = max(limit.Burst, 1)
+			valid[typeOf] = limit
+		}
+	}
+
+	return &rateLimiter{
+		limits:  valid,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// reserve takes a token for the task and returns how long the task has to wait for it, 0 when it can run right away.
+// Tasks of a type without a limit never wait
+func (r *rateLimiter) reserve(t *task.Task, now time.Time) time.Duration {
+	limit, ok := r.limits[t.TaskType]
+	if !ok {
+		return 0
+	}
+
+	key := string(t.TaskType)
+	if limit.PerUser {
+		key = fmt.Sprintf("%s/%s", t.TaskType, t.CreatedBy)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	b, ok := r.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		r.buckets[key] = b
+	}
+
+	// Refill for the time passed since the last reservation, never above the burst
+	b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*limit.Rate, float64(limit.Burst))
+	b.last = now
+	b.tokens--
+
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens / limit.Rate * float64(time.Second))
+}
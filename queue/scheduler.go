@@ -0,0 +1,385 @@
+package queue
+
+import (
+	"container/heap"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/sinderpl/AsyncTaskProcessor/task"
+)
+
+// Package queue/scheduler keeps track of the tasks awaiting a worker and decides which one is dispatched next
+
+// scheduled wraps a task with the data the scheduler orders it by
+type scheduled struct {
+	t       *task.Task
+	readyAt time.Time // when the task becomes eligible for processing, once ready when it entered its current level
+	since   time.Time // when the task became ready, unlike readyAt it is not moved by aging
+	level   int       // effective priority level, starts at the task priority and is raised by aging
+	seq     uint64    // insertion order, keeps tasks with equal keys in FIFO order
+	index   int       // position in the heap, maintained by the heap interface
+	heap    *taskHeap // heap the task is held in
+}
+
+// taskHeap is a min heap of scheduled tasks ordered by the less function
+type taskHeap struct {
+	items []*scheduled
+	less  func(a, b *scheduled) bool
+}
+
+func (h *taskHeap) Len() int           { return len(h.items) }
+func (h *taskHeap) Less(i, j int) bool { return h.less(h.items[i], h.items[j]) }
+
+func (h *taskHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+	h.items[i].index = i
+	h.items[j].index = j
+}
+
+func (h *taskHeap) Push(x any) {
+	item := x.(*scheduled)
+	item.index = len(h.items)
+	h.items = append(h.items, item)
+}
+
+func (h *taskHeap) Pop() any {
+	old := h.items
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	item.index = -1
+	h.items = old[:n-1]
+	return item
+}
+
+func (h *taskHeap) peek() *scheduled {
+	if len(h.items) == 0 {
+		return nil
+	}
+	return h.items[0]
+}
+
+// readyLevel holds the ready tasks of a priority level in a heap per task type, so a type which can't be dispatched
+// is skipped as a whole instead of scanning every one of its tasks
+type readyLevel struct {
+	byType map[task.TypeOf]*taskHeap
+	less   func(a, b *scheduled) bool
+}
+
+func (l *readyLevel) push(item *scheduled) {
+	h, ok := l.byType[item.t.TaskType]
+	if !ok {
+		h = &taskHeap{less: l.less}
+		l.byType[item.t.TaskType] = h
+	}
+
+	item.heap = h
+	heap.Push(h, item)
+}
+
+// peek returns the first task of the level in heap order, nil when the level is empty
+func (l *readyLevel) peek() *scheduled {
+	return l.first(func(*task.Task) bool { return true })
+}
+
+// first returns the first task in heap order whose type is allowed. allowed only depends on the task type so
+// checking the first task of every type is enough
+func (l *readyLevel) first(allowed func(t *task.Task) bool) *scheduled {
+	var first *scheduled
+	for _, h := range l.byType {
+		if top := h.peek(); top != nil && (first == nil || l.less(top, first)) && allowed(top.t) {
+			first = top
+		}
+	}
+
+	return first
+}
+
+// remove takes the task out of the heap of its type, an emptied heap is dropped
+func (l *readyLevel) remove(item *scheduled) {
+	h := item.heap
+	heap.Remove(h, item.index)
+	if h.Len() == 0 {
+		delete(l.byType, item.t.TaskType)
+	}
+}
+
+// items returns every task of the level in no particular order
+func (l *readyLevel) items() []*scheduled {
+	items := make([]*scheduled, 0)
+	for _, h := range l.byType {
+		items = append(items, h.items...)
+	}
+
+	return items
+}
+
+// scheduler holds the awaiting tasks, tasks that can be processed right away are kept in a ready heap per priority
+// level and task type ordered by how long they have been eligible, tasks in backoff are kept in the delayed heap ordered by
+// BackOffUntil and are moved over once it expires.
+// Ready tasks are picked with weighted round robin, every round each level can dispatch as many tasks as its weight
+// before the lower levels get their turn, so every level gets a guaranteed share of the workers.
+// With aging enabled a ready task which waited agingThreshold at its level is promoted to the level above, the task
+// priority itself is left untouched so the original priority is what gets persisted
+type scheduler struct {
+	mutex   sync.Mutex
+	seq     uint64
+	ready   []readyLevel // indexed by priority level
+	delayed taskHeap
+	byId    map[string]*scheduled // every task held by the scheduler, used to remove a task by id
+
+	weights []int // tasks each level can dispatch per round
+	credits []int // tasks each level can still dispatch in the current round
+
+	agingThreshold time.Duration // 0 disables aging
+}
+
+// newScheduler creates a scheduler with a level per weight, the weight at index 0 belongs to the lowest priority
+func newScheduler(weights []int, agingThreshold time.Duration) *scheduler {
+	s := &scheduler{
+		ready: make([]readyLevel, len(weights)),
+		delayed: taskHeap{less: func(a, b *scheduled) bool {
+			if !a.readyAt.Equal(b.readyAt) {
+				return a.readyAt.Before(b.readyAt)
+			}
+			return a.seq < b.seq
+		}},
+		byId:    make(map[string]*scheduled),
+		weights: weights,
+		credits: make([]int, len(weights)),
+
+		agingThreshold: agingThreshold,
+	}
+
+	for level := range s.ready {
+		s.ready[level].byType = make(map[task.TypeOf]*taskHeap)
+		s.ready[level].less = func(a, b *scheduled) bool {
+			if !a.readyAt.Equal(b.readyAt) {
+				return a.readyAt.Before(b.readyAt)
+			}
+			return a.seq < b.seq
+		}
+	}
+	copy(s.credits, s.weights)
+
+	return s
+}
+
+// level returns the ready heap level of the task, priorities above the supported levels are capped
+func (s *scheduler) level(t *task.Task) int {
+	level := int(t.Priority)
+	if level >= len(s.ready) {
+		return len(s.ready) - 1
+	}
+	if level < 0 {
+		return 0
+	}
+	return level
+}
+
+// push adds the task to the ready or delayed heap depending on its backoff
+func (s *scheduler) push(t *task.Task, now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.seq++
+	item := &scheduled{t: t, readyAt: now, level: s.level(t), seq: s.seq}
+	s.byId[t.Id] = item
+
+	if t.BackOffUntil != nil && t.BackOffUntil.After(now) {
+		item.readyAt = *t.BackOffUntil
+		s.pushDelayedItem(item)
+		return
+	}
+
+	item.since = now
+	s.ready[item.level].push(item)
+}
+
+func (s *scheduler) pushDelayedItem(item *scheduled) {
+	item.heap = &s.delayed
+	heap.Push(&s.delayed, item)
+}
+
+// pushDelayed adds the task to the delayed heap until the time it was deferred to
+func (s *scheduler) pushDelayed(t *task.Task, until time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.seq++
+	item := &scheduled{t: t, readyAt: until, level: s.level(t), seq: s.seq}
+	s.byId[t.Id] = item
+
+	s.pushDelayedItem(item)
+}
+
+// promoteDue moves every delayed task whose backoff has expired over to the ready heap and ages the ready tasks
+func (s *scheduler) promoteDue(now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for next := s.delayed.peek(); next != nil && !next.readyAt.After(now); next = s.delayed.peek() {
+		heap.Pop(&s.delayed)
+		next.since = next.readyAt
+		s.ready[next.level].push(next)
+	}
+
+	if s.agingThreshold <= 0 {
+		return
+	}
+
+	// Going up from the lowest level lets a task which waited several thresholds climb several levels at once
+	top := len(s.ready) - 1
+	for level := 0; level < top; level++ {
+		for next := s.ready[level].peek(); next != nil && !next.readyAt.Add(s.agingThreshold).After(now); next = s.ready[level].peek() {
+			s.ready[level].remove(next)
+			next.level++
+			next.readyAt = next.readyAt.Add(s.agingThreshold)
+			s.ready[next.level].push(next)
+			slog.Debug(fmt.Sprintf("task %s aged from priority level %d to %d", next.t.Id, level, next.level))
+		}
+	}
+}
+
+// popReady removes and returns the next ready task picked by weighted round robin, starting from the highest
+// priority level with credits left in the current round. Tasks which are not allowed stay in their level while the
+// other tasks are picked, a level without an allowed task uses no credit. Returns nil when there is no allowed task.
+// allowed has to only depend on the task type, a blocked type is skipped without looking at its other tasks
+func (s *scheduler) popReady(allowed func(t *task.Task) bool) *task.Task {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for round := 0; round < 2; round++ {
+		for level := len(s.ready) - 1; level >= 0; level-- {
+			if s.credits[level] <= 0 {
+				continue
+			}
+
+			item := s.ready[level].first(allowed)
+			if item == nil {
+				continue
+			}
+
+			s.ready[level].remove(item)
+
+			s.credits[level]--
+			delete(s.byId, item.t.Id)
+			return item.t
+		}
+
+		// Every level with allowed tasks has used up its share, start a new round
+		copy(s.credits, s.weights)
+	}
+
+	return nil
+}
+
+// nextDue returns when the earliest delayed task becomes eligible or the next ready task is due to be aged
+func (s *scheduler) nextDue() (time.Time, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var due time.Time
+	found := false
+
+	if next := s.delayed.peek(); next != nil {
+		due, found = next.readyAt, true
+	}
+
+	if s.agingThreshold > 0 {
+		for level := 0; level < len(s.ready)-1; level++ {
+			if next := s.ready[level].peek(); next != nil {
+				if aged := next.readyAt.Add(s.agingThreshold); !found || aged.Before(due) {
+					due, found = aged, true
+				}
+			}
+		}
+	}
+
+	return due, found
+}
+
+// drain removes and returns every task held by the scheduler
+func (s *scheduler) drain() []*task.Task {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	tasks := make([]*task.Task, 0, len(s.byId))
+	for level := range s.ready {
+		for _, item := range s.ready[level].items() {
+			tasks = append(tasks, item.t)
+		}
+		s.ready[level].byType = make(map[task.TypeOf]*taskHeap)
+	}
+	for _, item := range s.delayed.items {
+		tasks = append(tasks, item.t)
+	}
+	s.delayed.items = nil
+	s.byId = make(map[string]*scheduled)
+
+	return tasks
+}
+
+// remove takes the task out of the scheduler before it is dispatched. Returns nil when the scheduler does not hold it
+func (s *scheduler) remove(id string) *task.Task {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	item, ok := s.byId[id]
+	if !ok {
+		return nil
+	}
+	delete(s.byId, id)
+
+	if item.heap == &s.delayed {
+		heap.Remove(&s.delayed, item.index)
+	} else {
+		s.ready[item.level].remove(item)
+	}
+
+	return item.t
+}
+
+// findUnique returns the held task of the type with the unique key, nil when the scheduler does not hold one
+func (s *scheduler) findUnique(taskType task.TypeOf, uniqueKey string) *task.Task {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, item := range s.byId {
+		if item.t.TaskType == taskType && item.t.UniqueKey == uniqueKey {
+			return item.t
+		}
+	}
+
+	return nil
+}
+
+// readyStats returns the amount of ready tasks and how long the one which became ready first has been waiting
+func (s *scheduler) readyStats(now time.Time, counted func(t *task.Task) bool) (int, time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	count := 0
+	var oldest time.Duration
+	for level := range s.ready {
+		for _, item := range s.ready[level].items() {
+			if !counted(item.t) {
+				continue
+			}
+			count++
+			oldest = max(oldest, now.Sub(item.since))
+		}
+	}
+
+	return count, oldest
+}
+
+// len returns the amount of tasks held by the scheduler
+func (s *scheduler) len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return len(s.byId)
+}
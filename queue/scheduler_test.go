@@ -0,0 +1,141 @@
+package queue
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sinderpl/AsyncTaskProcessor/task"
+)
+
+func newScheduledTask(id string, taskType task.TypeOf, priority task.ExecutionPriority) *task.Task {
+	return &task.Task{Id: id, TaskType: taskType, Priority: priority}
+}
+
+func allowAll(*task.Task) bool { return true }
+
+func TestSchedulerPromoteDueOnlyOnceDue(t *testing.T) {
+	now := time.Now()
+	s := newScheduler([]int{1, 1}, 0)
+
+	until := now.Add(10 * time.Second)
+	delayed := newScheduledTask("delayed", "a", task.Low)
+	delayed.BackOffUntil = &until
+	s.push(delayed, now)
+
+	if due, ok := s.nextDue(); !ok || !due.Equal(until) {
+		t.Fatalf("next due: got %v %v, want %v", due, ok, until)
+	}
+
+	s.promoteDue(until.Add(-time.Nanosecond))
+	if got := s.popReady(allowAll); got != nil {
+		t.Fatalf("got %s before its backoff expired", got.Id)
+	}
+
+	s.promoteDue(until)
+	if got := s.popReady(allowAll); got == nil || got.Id != "delayed" {
+		t.Fatalf("got %v, want the delayed task once due", got)
+	}
+	if s.len() != 0 {
+		t.Errorf("scheduler still holds %d tasks", s.len())
+	}
+}
+
+func TestSchedulerPopReadySkipsBlockedType(t *testing.T) {
+	now := time.Now()
+	s := newScheduler([]int{1}, 0)
+
+	s.push(newScheduledTask("blocked-1", "blocked", task.Low), now)
+	s.push(newScheduledTask("blocked-2", "blocked", task.Low), now)
+	s.push(newScheduledTask("open-1", "open", task.Low), now)
+
+	notBlocked := func(t *task.Task) bool { return t.TaskType != "blocked" }
+	if got := s.popReady(notBlocked); got == nil || got.Id != "open-1" {
+		t.Fatalf("got %v, want open-1", got)
+	}
+	if got := s.popReady(notBlocked); got != nil {
+		t.Fatalf("got %s from a blocked type", got.Id)
+	}
+
+	for _, want := range []string{"blocked-1", "blocked-2"} {
+		if got := s.popReady(allowAll); got == nil || got.Id != want {
+			t.Fatalf("got %v, want %s", got, want)
+		}
+	}
+}
+
+func TestSchedulerPopReadyWeights(t *testing.T) {
+	now := time.Now()
+	s := newScheduler([]int{1, 3}, 0)
+
+	for i := 0; i < 12; i++ {
+		s.push(newScheduledTask(fmt.Sprintf("high-%d", i), "a", task.High), now)
+	}
+	for i := 0; i < 4; i++ {
+		s.push(newScheduledTask(fmt.Sprintf("low-%d", i), "a", task.Low), now)
+	}
+
+	// Every round High dispatches three tasks before Low gets one
+	for cycle := 0; cycle < 4; cycle++ {
+		for i, want := range []task.ExecutionPriority{task.High, task.High, task.High, task.Low} {
+			got := s.popReady(allowAll)
+			if got == nil || got.Priority != want {
+				t.Fatalf("cycle %d pick %d: got %v, want priority %d", cycle, i, got, want)
+			}
+		}
+	}
+
+	if got := s.popReady(allowAll); got != nil {
+		t.Errorf("got %s from an empty scheduler", got.Id)
+	}
+}
+
+func TestSchedulerPopReadyLevelWithoutTasksUsesNoCredit(t *testing.T) {
+	now := time.Now()
+	s := newScheduler([]int{1, 3}, 0)
+
+	for i := 0; i < 3; i++ {
+		s.push(newScheduledTask(fmt.Sprintf("low-%d", i), "a", task.Low), now)
+	}
+
+	for i := 0; i < 3; i++ {
+		if got := s.popReady(allowAll); got == nil || got.Id != fmt.Sprintf("low-%d", i) {
+			t.Fatalf("pick %d: got %v, want low-%d", i, got, i)
+		}
+	}
+}
+
+func TestSchedulerAging(t *testing.T) {
+	now := time.Now()
+	threshold := time.Minute
+	s := newScheduler([]int{1, 1, 1}, threshold)
+
+	s.push(newScheduledTask("low", "a", task.Low), now)
+
+	if due, ok := s.nextDue(); !ok || !due.Equal(now.Add(threshold)) {
+		t.Fatalf("next due: got %v %v, want %v", due, ok, now.Add(threshold))
+	}
+
+	s.promoteDue(now.Add(threshold - time.Nanosecond))
+	if level := s.byId["low"].level; level != 0 {
+		t.Fatalf("aged to level %d before the threshold", level)
+	}
+
+	s.promoteDue(now.Add(threshold))
+	if level := s.byId["low"].level; level != 1 {
+		t.Fatalf("got level %d after the threshold, want 1", level)
+	}
+
+	// Waiting several thresholds climbs several levels but never past the top one
+	s.promoteDue(now.Add(10 * threshold))
+	item := s.byId["low"]
+	if item.level != 2 {
+		t.Fatalf("got level %d, want the top level 2", item.level)
+	}
+	if item.t.Priority != task.Low {
+		t.Errorf("aging changed the task priority to %d", item.t.Priority)
+	}
+	if !item.since.Equal(now) {
+		t.Errorf("aging moved the ready time to %v", item.since)
+	}
+}
@@ -1,8 +1,12 @@
 package queue
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -13,6 +17,8 @@ import (
 
 type worker struct {
 	Id string
+
+	retire context.CancelFunc // stops the worker from picking up new tasks, its task in flight still finishes
 }
 
 func createWorker() worker {
@@ -22,55 +28,267 @@ func createWorker() worker {
 }
 
 type WorkerPool struct {
-	TaskQueue chan task.Task
+	resultChan chan<- task.Task // the workers write the processed tasks back to this chan
+	workChan   <-chan task.Task // the workers pick tasks from this chan
+	picked     func()           // called whenever a worker frees up space on the work chan
+
+	wg         sync.WaitGroup
+	stopCtx    context.Context    // done once the pool should stop picking up tasks
+	workCtx    context.Context    // parent context of every task in flight
+	cancelWork context.CancelFunc // cancels the context of every task in flight
+
+	mutex     sync.Mutex
+	workers   []worker                      // workers picking up tasks, retired workers are removed straight away
+	running   map[string]context.CancelFunc // cancels a single task in flight by id
+	cancelled map[string]struct{}           // tasks cancelled before a worker picked them up
 }
 
-// Start starts the worker to process tasks from multiple channels.
-func (w worker) Start(resultChan chan task.Task, workerChan []chan task.Task) {
+// Start starts the worker to process tasks from the dispatch channel.
+// The worker stops picking up new tasks once stopCtx is done while tasks in flight run with the pool work context
+func (w worker) Start(stopCtx context.Context, pool *WorkerPool) {
+	pool.wg.Add(1)
 	go func() {
+		defer pool.wg.Done()
 		for {
-			// TODO improve different channel prioritisation
+			if stopCtx.Err() != nil {
+				slog.Info(fmt.Sprintf("worker %s stopped", w.Id))
+				return
+			}
+
 			select {
-			case t := <-workerChan[1]:
-				w.process(t, resultChan)
-			case t := <-workerChan[0]:
-				w.process(t, resultChan)
-			default:
-				time.Sleep(100 * time.Millisecond) // Avoid busy-wait, poll every so often for new work
+			case t := <-pool.workChan:
+				pool.picked()
+				w.process(pool, t)
+			case <-stopCtx.Done():
+				slog.Info(fmt.Sprintf("worker %s stopped", w.Id))
+				return
 			}
 		}
 	}()
 }
 
 // process starts the task processing implementation and returns any errors
-func (w worker) process(t task.Task, resultChan chan task.Task) {
+// the task runs with a context carrying its execution deadline if one is set
+func (w worker) process(pool *WorkerPool, t task.Task) {
+	// A task cancelled while waiting on the chan is handed straight back without being processed
+	if pool.takeCancelled(t.Id) {
+		t.Status = task.ProcessingCancelled
+		t.Error = context.Canceled
+		t.ErrorDetails = t.Error.Error()
+		pool.resultChan <- t
+		return
+	}
+
 	t.Status = task.Processing
 	currTime := time.Now().UTC()
 	t.StartedAt = &currTime
 	slog.Info(fmt.Sprintf("worker %s is processing task: %s with priority: %d \n", w.Id, t.Id, t.Priority))
-	err := t.ProcessableTask.ProcessTask()
+
+	var (
+		taskCtx context.Context
+		cancel  context.CancelFunc
+	)
+	if t.Timeout != nil {
+		taskCtx, cancel = context.WithTimeout(pool.workCtx, *t.Timeout)
+	} else {
+		taskCtx, cancel = context.WithCancel(pool.workCtx)
+	}
+
+	pool.track(t.Id, cancel)
+	err := t.ProcessableTask.ProcessTaskContext(taskCtx)
+	pool.untrack(t.Id)
+	cancel()
+
+	// A result which can't be stored will never be stored, retrying the task would not change that
+	if err == nil {
+		if t.Result, err = task.ResultOf(t.ProcessableTask); err != nil {
+			err = task.Permanent(err)
+		}
+	}
+
 	if err != nil {
 		t.Status = task.ProcessingAwaitingRetry
+		if errors.Is(err, context.DeadlineExceeded) {
+			t.Status = task.ProcessingTimedOut
+			err = fmt.Errorf("task exceeded its execution deadline of %s: %w", t.Timeout, err)
+		}
 		t.Error = err
 		t.ErrorDetails = err.Error()
 	}
-	resultChan <- t
-}
 
-// CreateWorkerPool initializes a new worker pool of size numWorkers and registers them to listen to 2 chans
-func CreateWorkerPool(numWorkers int, resultChan chan task.Task, workChans []chan task.Task) *WorkerPool {
+	finishedAt := time.Now().UTC()
+	attempt := task.Attempt{
+		Number:     len(t.Attempts) + 1,
+		WorkerId:   w.Id,
+		StartedAt:  currTime,
+		FinishedAt: finishedAt,
+		DurationMs: finishedAt.Sub(currTime).Milliseconds(),
+	}
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+
+	// Clip so appending never writes into a backing array shared with another copy of the task
+	t.Attempts = append(slices.Clip(t.Attempts), attempt)
 
-	pool := &WorkerPool{}
+	pool.resultChan <- t
+}
 
-	for i := 1; i <= numWorkers; i++ {
-		worker := createWorker()
-		worker.Start(resultChan, workChans)
+// CreateWorkerPool initializes a new worker pool of size numWorkers and registers them to listen to the work chan
+// the workers stop picking up tasks when the context is done, tasks in flight are only cancelled through Shutdown.
+// picked is called whenever a worker frees up space on a channel
+func CreateWorkerPool(ctx context.Context, numWorkers int, resultChan chan<- task.Task, workChan <-chan task.Task, picked func()) *WorkerPool {
+
+	pool := &WorkerPool{
+		resultChan: resultChan,
+		workChan:   workChan,
+		picked:     picked,
+		running:    make(map[string]context.CancelFunc),
+		cancelled:  make(map[string]struct{}),
 	}
 
+	pool.stopCtx = ctx
+	pool.workCtx, pool.cancelWork = context.WithCancel(context.WithoutCancel(ctx))
+
+	pool.mutex.Lock()
+	pool.addWorkers(numWorkers)
+	pool.mutex.Unlock()
+
 	return pool
 }
 
-// RegisterNewChan adds a new queue for the workers to listen to
-func (*WorkerPool) RegisterNewChan(newChan <-chan task.Task) {
+// Resize grows or shrinks the pool to size workers. Retired workers stop picking up tasks and finish the task
+// they are processing before they exit, new workers start picking up tasks right away
+func (p *WorkerPool) Resize(size int) error {
+	if size < 1 {
+		return fmt.Errorf("worker pool size must be at least 1, got %d", size)
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.stopCtx.Err() != nil {
+		return errors.New("worker pool is shutting down")
+	}
+
+	current := len(p.workers)
+	if size > current {
+		p.addWorkers(size - current)
+	}
+
+	for _, w := range p.workers[size:] {
+		w.retire()
+		slog.Info(fmt.Sprintf("worker %s retiring", w.Id))
+	}
+	p.workers = slices.Clip(p.workers[:size])
+
+	slog.Info(fmt.Sprintf("worker pool resized from %d to %d workers", current, size))
+
+	return nil
+}
+
+// Size returns the amount of workers picking up tasks
+func (p *WorkerPool) Size() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return len(p.workers)
+}
+
+// busy returns the amount of workers processing a task
+func (p *WorkerPool) busy() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return len(p.running)
+}
+
+// addWorkers starts n new workers, the caller holds the mutex
+func (p *WorkerPool) addWorkers(n int) {
+	for i := 0; i < n; i++ {
+		w := createWorker()
+
+		var workerCtx context.Context
+		workerCtx, w.retire = context.WithCancel(p.stopCtx)
+
+		w.Start(workerCtx, p)
+		p.workers = append(p.workers, w)
+	}
+}
+
+// Cancel cancels the context of the task if a worker is processing it, otherwise the task is skipped
+// once a worker picks it up. The cancelled task is written back to the result chan with a context.Canceled error
+func (p *WorkerPool) Cancel(id string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if cancel, ok := p.running[id]; ok {
+		cancel()
+		return
+	}
+
+	p.cancelled[id] = struct{}{}
+}
+
+// forget drops a cancellation which was requested for a task that will never be picked up again
+func (p *WorkerPool) forget(id string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	delete(p.cancelled, id)
+}
+
+// track registers the cancel func of a task in flight
+func (p *WorkerPool) track(id string, cancel context.CancelFunc) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.running[id] = cancel
+}
+
+// untrack removes the task once it is no longer in flight
+func (p *WorkerPool) untrack(id string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	delete(p.running, id)
+}
+
+// takeCancelled reports whether the task was cancelled before it was picked up and clears the cancellation
+func (p *WorkerPool) takeCancelled(id string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if _, ok := p.cancelled[id]; ok {
+		delete(p.cancelled, id)
+		return true
+	}
+
+	return false
+}
+
+// Shutdown waits for the workers to finish their tasks in flight, if the context is done first the tasks
+// in flight are cancelled and their results are still written back before it returns.
+// The context passed in to CreateWorkerPool must be done before calling Shutdown
+func (p *WorkerPool) Shutdown(ctx context.Context) {
+	// A resize in progress has started its workers before they are waited for
+	p.mutex.Lock()
+	p.workers = nil
+	p.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		slog.Warn("shutdown grace period exceeded, cancelling tasks in flight")
+		p.cancelWork()
+		<-done
+	}
 
+	p.cancelWork()
 }
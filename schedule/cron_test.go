@@ -0,0 +1,124 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"foo * * * *",
+		"* * * * funday",
+	} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("%q: expected an error", expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// 2024-06-01 is a saturday
+	from := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		expr  string
+		after time.Time
+		want  time.Time
+	}{
+		{"every minute", "* * * * *", from, from.Add(time.Minute)},
+		{"list", "0 8,20 * * *", from, time.Date(2024, 6, 1, 20, 0, 0, 0, time.UTC)},
+		{"range with step", "5-20/5 * * * *", from.Add(6 * time.Minute), time.Date(2024, 6, 1, 12, 10, 0, 0, time.UTC)},
+		{"value with step", "5/15 * * * *", from.Add(21 * time.Minute), time.Date(2024, 6, 1, 12, 35, 0, 0, time.UTC)},
+		{"weekdays by name", "*/15 9-17 * * mon-fri", from, time.Date(2024, 6, 3, 9, 0, 0, 0, time.UTC)},
+		{"month by name", "0 0 1 jan *", from, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"macro", "@weekly", from, time.Date(2024, 6, 2, 0, 0, 0, 0, time.UTC)},
+		{"7 is sunday", "0 0 * * 7", from, time.Date(2024, 6, 2, 0, 0, 0, 0, time.UTC)},
+		{"range up to 7", "0 0 * * 5-7", from, time.Date(2024, 6, 2, 0, 0, 0, 0, time.UTC)},
+		{"either day field", "0 0 13 * fri", from, time.Date(2024, 6, 7, 0, 0, 0, 0, time.UTC)},
+		{"either day field, day of month first", "0 0 13 * fri", time.Date(2024, 6, 8, 0, 0, 0, 0, time.UTC), time.Date(2024, 6, 13, 0, 0, 0, 0, time.UTC)},
+		{"wildcard step day needs both", "0 0 */10 * mon", from, time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)},
+		{"leap day", "0 0 29 2 *", from, time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"never fires", "0 0 30 2 *", from, time.Time{}},
+	}
+
+	for _, tt := range tests {
+		c, err := ParseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("%s: failed to parse %q: %v", tt.name, tt.expr, err)
+		}
+
+		if got := c.Next(tt.after); !got.Equal(tt.want) {
+			t.Errorf("%s: %q after %v got %v, want %v", tt.name, tt.expr, tt.after, got, tt.want)
+		}
+	}
+}
+
+func TestCronNextDaylightSaving(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone data not available: %v", err)
+	}
+
+	// Clocks go forward at 2024-03-10 02:00 and back at 2024-11-03 02:00
+	edt := time.FixedZone("EDT", -4*60*60)
+	est := time.FixedZone("EST", -5*60*60)
+
+	tests := []struct {
+		name  string
+		expr  string
+		after time.Time
+		want  []time.Time
+	}{
+		{
+			name:  "time in the spring forward gap is skipped",
+			expr:  "30 2 * * *",
+			after: time.Date(2024, 3, 9, 12, 0, 0, 0, loc),
+			want:  []time.Time{time.Date(2024, 3, 11, 2, 30, 0, 0, edt)},
+		},
+		{
+			name:  "hourly across spring forward",
+			expr:  "0 * * * *",
+			after: time.Date(2024, 3, 10, 0, 30, 0, 0, loc),
+			want:  []time.Time{time.Date(2024, 3, 10, 1, 0, 0, 0, est), time.Date(2024, 3, 10, 3, 0, 0, 0, edt)},
+		},
+		{
+			name:  "time repeated by fall back fires once",
+			expr:  "30 1 * * *",
+			after: time.Date(2024, 11, 2, 12, 0, 0, 0, loc),
+			want:  []time.Time{time.Date(2024, 11, 3, 1, 30, 0, 0, edt), time.Date(2024, 11, 4, 1, 30, 0, 0, est)},
+		},
+		{
+			name:  "hourly across fall back",
+			expr:  "0 * * * *",
+			after: time.Date(2024, 11, 3, 0, 30, 0, 0, loc),
+			want:  []time.Time{time.Date(2024, 11, 3, 1, 0, 0, 0, edt), time.Date(2024, 11, 3, 2, 0, 0, 0, est)},
+		},
+	}
+
+	for _, tt := range tests {
+		c, err := ParseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("%s: failed to parse %q: %v", tt.name, tt.expr, err)
+		}
+
+		after := tt.after
+		for i, want := range tt.want {
+			got := c.Next(after)
+			if !got.Equal(want) {
+				t.Fatalf("%s: run %d got %v, want %v", tt.name, i, got, want)
+			}
+			after = got
+		}
+	}
+}
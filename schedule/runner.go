@@ -0,0 +1,166 @@
+package schedule
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/sinderpl/AsyncTaskProcessor/task"
+)
+
+// Package schedule/runner deals with firing the due schedules and handing their tasks over to the queue
+
+// Store is the persistence the runner needs, implemented by the storage package
+type Store interface {
+	GetDueSchedules(now time.Time) ([]*Schedule, error)
+	// FireSchedule claims the run of the schedule at runAt by moving it to its new NextRunAt and creates the task
+	// in the same transaction. Returns false when another runner already claimed the run, the schedule was paused
+	// or the work of the run is still pending from an earlier one
+	FireSchedule(s *Schedule, runAt time.Time, t *task.Task) (bool, error)
+}
+
+type runnerOption func(r *Runner)
+
+// Runner checks for due schedules every interval and enqueues a task for each of them
+type Runner struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	interval time.Duration
+	store    Store
+	taskChan *chan []*task.Task
+
+	done chan struct{} // closed once the runner has stopped
+}
+
+// CreateRunner creates the runner with predefined options
+func CreateRunner(ctx context.Context, opts ...runnerOption) (*Runner, error) {
+	r := &Runner{
+		interval: 10 * time.Second,
+		done:     make(chan struct{}),
+	}
+
+	r.ctx, r.cancel = context.WithCancel(ctx)
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	if r.store == nil {
+		return nil, fmt.Errorf("schedule store must be set")
+	}
+
+	if r.taskChan == nil {
+		return nil, fmt.Errorf("task channel must be set")
+	}
+
+	return r, nil
+}
+
+// WithStore *required* persists the schedules and their tasks
+func WithStore(store Store) runnerOption {
+	return func(r *Runner) {
+		r.store = store
+	}
+}
+
+// WithTaskChan *required* the tasks of the fired schedules are sent to the queue on this chan
+func WithTaskChan(taskChan *chan []*task.Task) runnerOption {
+	return func(r *Runner) {
+		r.taskChan = taskChan
+	}
+}
+
+// WithInterval how often the runner checks for due schedules
+func WithInterval(interval time.Duration) runnerOption {
+	return func(r *Runner) {
+		if interval > 0 {
+			r.interval = interval
+		}
+	}
+}
+
+// Start starts checking for due schedules in the background
+func (r *Runner) Start() {
+	go r.run()
+}
+
+// Shutdown stops the runner and waits for the check in progress to finish
+func (r *Runner) Shutdown() {
+	r.cancel()
+	<-r.done
+}
+
+func (r *Runner) run() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	slog.Info("schedule runner has started")
+	for {
+		r.fireDue(time.Now().UTC())
+
+		select {
+		case <-r.ctx.Done():
+			slog.Info("schedule runner stopped, context cancelled")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// fireDue fires every schedule whose next run is due. Runs missed while the service was down fire once,
+// the schedule then carries on from its next run after now
+func (r *Runner) fireDue(now time.Time) {
+	schedules, err := r.store.GetDueSchedules(now)
+	if err != nil {
+		slog.Error(fmt.Sprintf("failed to load due schedules: %v", err))
+		return
+	}
+
+	tasks := make([]*task.Task, 0, len(schedules))
+	for _, s := range schedules {
+		if err := s.Parse(); err != nil {
+			slog.Error(fmt.Sprintf("failed to parse schedule %s: %v", s.Id, err))
+			continue
+		}
+
+		runAt := *s.NextRunAt
+
+		// A broken run is still claimed so the schedule moves on instead of failing on every check
+		t, err := s.NewTask(runAt)
+		if err != nil {
+			slog.Error(fmt.Sprintf("failed to create task of schedule %s: %v", s.Id, err))
+			t = nil
+		}
+
+		s.LastRunAt = &runAt
+		s.NextRunAt = s.NextRun(now)
+
+		// The claim only succeeds while the stored next run is still runAt, so a run is never fired twice
+		// by a restart or another instance
+		claimed, err := r.store.FireSchedule(s, runAt, t)
+		if err != nil {
+			slog.Error(fmt.Sprintf("failed to fire schedule %s: %v", s.Id, err))
+			continue
+		}
+
+		if !claimed || t == nil {
+			continue
+		}
+
+		slog.Info(fmt.Sprintf("schedule %s fired task %s", s.Id, t.Id))
+		tasks = append(tasks, t)
+	}
+
+	if len(tasks) == 0 {
+		return
+	}
+
+	// The tasks are already stored as awaiting, if the queue is gone they are recovered on the next start
+	select {
+	case *r.taskChan <- tasks:
+	case <-r.ctx.Done():
+	}
+}
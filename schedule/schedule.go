@@ -0,0 +1,209 @@
+package schedule
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sinderpl/AsyncTaskProcessor/task"
+)
+
+// Package schedule deals with recurring cron schedules which create a new task every time they fire
+
+// Status enum describing whether the schedule fires
+type Status string
+
+const (
+	Active Status = "Active"
+	Paused Status = "Paused"
+)
+
+// Schedule creates a task of its type from the payload template every time the cron expression fires
+type Schedule struct {
+	Id              string                 `json:"id"`
+	Cron            string                 `json:"cron"`
+	Timezone        string                 `json:"timezone"`
+	TaskType        task.TypeOf            `json:"taskType"`
+	Priority        task.ExecutionPriority `json:"priority"`
+	PayloadTemplate string                 `json:"payloadTemplate"` // text/template rendering the task payload json
+	Timeout         string                 `json:"timeout,omitempty"`
+	MaxRetries      *int                   `json:"maxRetries,omitempty"`
+	Status          Status                 `json:"status"`
+
+	NextRunAt *time.Time `json:"nextRunAt,omitempty"` // nil when the expression never fires again
+	LastRunAt *time.Time `json:"lastRunAt,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+	CreatedBy string     `json:"createdBy"`
+
+	cron     *Cron
+	location *time.Location
+	template *template.Template
+}
+
+// TemplateData is what the payload template is rendered with, e.g. {"reportType": "Daily {{.RunAt.Format "2006-01-02"}}"}
+type TemplateData struct {
+	ScheduleId string
+	RunAt      time.Time // the time the schedule fired for in the schedule timezone
+}
+
+type option func(s *Schedule)
+
+// WithCron *Required* sets the cron expression
+func WithCron(expr string) option {
+	return func(s *Schedule) {
+		s.Cron = expr
+	}
+}
+
+// WithTimezone sets the timezone the cron expression is evaluated in, defaults to UTC
+func WithTimezone(timezone string) option {
+	return func(s *Schedule) {
+		if timezone != "" {
+			s.Timezone = timezone
+		}
+	}
+}
+
+// WithType *Required* sets the type of the created tasks
+func WithType(typeOf task.TypeOf) option {
+	return func(s *Schedule) {
+		s.TaskType = typeOf
+	}
+}
+
+// WithPriority sets the priority of the created tasks
+func WithPriority(priority task.ExecutionPriority) option {
+	return func(s *Schedule) {
+		s.Priority = priority
+	}
+}
+
+// WithPayloadTemplate sets the template the payload of every created task is rendered from
+func WithPayloadTemplate(payload string) option {
+	return func(s *Schedule) {
+		s.PayloadTemplate = payload
+	}
+}
+
+// WithTimeout sets the execution deadline of the created tasks, an invalid timeout fails the creation
+func WithTimeout(timeout string) option {
+	return func(s *Schedule) {
+		s.Timeout = timeout
+	}
+}
+
+// WithMaxRetries overrides the amount of times the created tasks are retried
+func WithMaxRetries(retries *int) option {
+	return func(s *Schedule) {
+		s.MaxRetries = retries
+	}
+}
+
+// WithCreatedBy *Required* sets created by user id, the created tasks are created by the same user
+func WithCreatedBy(id string) option {
+	return func(s *Schedule) {
+		s.CreatedBy = id
+	}
+}
+
+// CreateSchedule creates and validates a schedule with the supplied options, the first run is the first time
+// the expression fires after now
+func CreateSchedule(opts ...option) (*Schedule, error) {
+	s := &Schedule{
+		Id:        uuid.New().String(),
+		Timezone:  "UTC",
+		Status:    Active,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.CreatedBy == "" {
+		return nil, fmt.Errorf("creator user id must be set")
+	}
+
+	if _, err := task.ParseTimeout(s.Timeout); err != nil {
+		return nil, err
+	}
+
+	if err := s.Parse(); err != nil {
+		return nil, err
+	}
+
+	s.NextRunAt = s.NextRun(s.CreatedAt)
+	if s.NextRunAt == nil {
+		return nil, fmt.Errorf("cron expression never fires")
+	}
+
+	// Dry run the template so a broken schedule is rejected now rather than on every tick
+	if _, err := s.NewTask(*s.NextRunAt); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Parse parses the cron expression, timezone and payload template, it has to be called on schedules loaded
+// from storage before they can fire
+func (s *Schedule) Parse() error {
+	var err error
+	if s.cron, err = ParseCron(s.Cron); err != nil {
+		return fmt.Errorf("invalid cron expression: %v", err)
+	}
+
+	if s.location, err = time.LoadLocation(s.Timezone); err != nil {
+		return fmt.Errorf("invalid timezone: %v", err)
+	}
+
+	if s.template, err = template.New(s.Id).Option("missingkey=error").Parse(s.PayloadTemplate); err != nil {
+		return fmt.Errorf("invalid payload template: %v", err)
+	}
+
+	return nil
+}
+
+// NextRun returns when the schedule fires next after the given time, nil when it never fires again
+func (s *Schedule) NextRun(after time.Time) *time.Time {
+	next := s.cron.Next(after.In(s.location))
+	if next.IsZero() {
+		return nil
+	}
+
+	next = next.UTC()
+
+	return &next
+}
+
+// NewTask creates the task of a single run of the schedule
+func (s *Schedule) NewTask(runAt time.Time) (*task.Task, error) {
+	var payload bytes.Buffer
+	err := s.template.Execute(&payload, TemplateData{
+		ScheduleId: s.Id,
+		RunAt:      runAt.In(s.location),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to render payload template: %v", err)
+	}
+
+	if !json.Valid(payload.Bytes()) {
+		return nil, fmt.Errorf("payload template did not render valid json")
+	}
+
+	t, err := task.CreateTask(
+		task.WithType(s.TaskType),
+		task.WithPriority(s.Priority),
+		task.WithTimeout(s.Timeout),
+		task.WithMaxRetries(s.MaxRetries),
+		task.WithCreatedBy(s.CreatedBy),
+		task.WithPayload(payload.Bytes()))
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
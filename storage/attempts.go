@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"github.com/sinderpl/AsyncTaskProcessor/task"
+)
+
+// Package storage/attempts deals with persisting the history of processing attempts per task
+
+// CreateAttempt records a single processing attempt of the task
+func (p *PostgresStore) CreateAttempt(taskId string, a task.Attempt) error {
+	query := `
+		insert into task_attempts
+		(taskId, attempt, workerId, startedAt, finishedAt, durationMs, error)
+		values ($1, $2, $3, $4, $5, $6, $7)
+		`
+
+	_, err := p.db.Exec(query, taskId, a.Number, a.WorkerId, a.StartedAt, a.FinishedAt, a.DurationMs, a.Error)
+
+	return err
+}
+
+// GetTaskAttempts retrieves every processing attempt of the task in order
+func (p *PostgresStore) GetTaskAttempts(taskId string) ([]task.Attempt, error) {
+	rows, err := p.db.Query(`
+		select attempt, workerId, startedAt, finishedAt, durationMs, error
+		from task_attempts where taskId = $1 order by attempt`, taskId)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attempts := make([]task.Attempt, 0)
+	for rows.Next() {
+		a := task.Attempt{}
+		if err := rows.Scan(&a.Number, &a.WorkerId, &a.StartedAt, &a.FinishedAt, &a.DurationMs, &a.Error); err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, a)
+	}
+
+	return attempts, rows.Err()
+}
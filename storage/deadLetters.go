@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/sinderpl/AsyncTaskProcessor/task"
+)
+
+// Package storage/deadLetters deals with persisting tasks which failed for good in the dead letter queue
+
+// DeadLetter is a snapshot of a task that ran out of retries or failed with a permanent error
+type DeadLetter struct {
+	TaskId    string                 `json:"taskId"`
+	TaskType  task.TypeOf            `json:"taskType"`
+	Priority  task.ExecutionPriority `json:"priority"`
+	CreatedBy string                 `json:"createdBy"`
+	Payload   json.RawMessage        `json:"payload"`
+	Error     string                 `json:"error"`
+	Retries   int                    `json:"retries"`
+	Attempts  []task.Attempt         `json:"attempts"`
+	FailedAt  time.Time              `json:"failedAt"`
+}
+
+// DeadLetterFilter narrows down dead letter queries, zero values are not filtered on
+type DeadLetterFilter struct {
+	TaskIds      []string
+	TaskType     task.TypeOf
+	CreatedBy    string
+	FailedAfter  *time.Time
+	FailedBefore *time.Time
+	Limit        int
+}
+
+// NewDeadLetter creates the dead letter entry of a failed task
+func NewDeadLetter(t *task.Task) *DeadLetter {
+	return &DeadLetter{
+		TaskId:    t.Id,
+		TaskType:  t.TaskType,
+		Priority:  t.Priority,
+		CreatedBy: t.CreatedBy,
+		Payload:   t.Payload,
+		Error:     t.ErrorDetails,
+		Retries:   t.Retries,
+		Attempts:  t.Attempts,
+		FailedAt:  time.Now().UTC(),
+	}
+}
+
+// where builds the where clause and its arguments for the filter
+func (f DeadLetterFilter) where() (string, []any) {
+	conditions := make([]string, 0)
+	args := make([]any, 0)
+
+	add := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if len(f.TaskIds) > 0 {
+		add("taskId = any($%d)", pq.Array(f.TaskIds))
+	}
+	if f.TaskType != "" {
+		add("taskType = $%d", f.TaskType)
+	}
+	if f.CreatedBy != "" {
+		add("createdBy = $%d", f.CreatedBy)
+	}
+	if f.FailedAfter != nil {
+		add("failedAt >= $%d", *f.FailedAfter)
+	}
+	if f.FailedBefore != nil {
+		add("failedAt < $%d", *f.FailedBefore)
+	}
+
+	if len(conditions) == 0 {
+		return "", args
+	}
+
+	return " where " + strings.Join(conditions, " and "), args
+}
+
+// CreateDeadLetter records the failed task in the dead letter queue, replacing an earlier entry of the same task
+func (p *PostgresStore) CreateDeadLetter(d *DeadLetter) error {
+	attempts, err := json.Marshal(d.Attempts)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		insert into dead_letters
+		(taskId, taskType, priority, createdBy, payload, error, retries, attempts, failedAt)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		on conflict (taskId) do update set
+		payload = excluded.payload, error = excluded.error, retries = excluded.retries,
+		attempts = excluded.attempts, failedAt = excluded.failedAt
+		`
+
+	_, err = p.db.Exec(query, d.TaskId, d.TaskType, d.Priority, d.CreatedBy, d.Payload, d.Error, d.Retries,
+		attempts, d.FailedAt)
+
+	return err
+}
+
+// ListDeadLetters retrieves the dead letters matching the filter, most recently failed first
+func (p *PostgresStore) ListDeadLetters(filter DeadLetterFilter) ([]*DeadLetter, error) {
+	where, args := filter.where()
+
+	query := `select taskId, taskType, priority, createdBy, payload, error, retries, attempts, failedAt
+		from dead_letters` + where + " order by failedAt desc"
+
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" limit $%d", len(args))
+	}
+
+	rows, err := p.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deadLetters := make([]*DeadLetter, 0)
+	for rows.Next() {
+		d := new(DeadLetter)
+		var attempts []byte
+
+		err := rows.Scan(&d.TaskId, &d.TaskType, &d.Priority, &d.CreatedBy, &d.Payload, &d.Error, &d.Retries,
+			&attempts, &d.FailedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(attempts) > 0 {
+			if err := json.Unmarshal(attempts, &d.Attempts); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal attempts of dead letter %s: %v", d.TaskId, err)
+			}
+		}
+
+		deadLetters = append(deadLetters, d)
+	}
+
+	return deadLetters, rows.Err()
+}
+
+// DeleteDeadLetters removes the dead letters matching the filter and returns how many were removed, with a limit
+// only the most recently failed ones are removed, the same ones ListDeadLetters returns
+func (p *PostgresStore) DeleteDeadLetters(filter DeadLetterFilter) (int64, error) {
+	where, args := filter.where()
+
+	query := "delete from dead_letters" + where
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query = fmt.Sprintf(`delete from dead_letters where taskId in
+			(select taskId from dead_letters%s order by failedAt desc limit $%d)`, where, len(args))
+	}
+
+	res, err := p.db.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/sinderpl/AsyncTaskProcessor/task"
+)
+
+// Package storage/idempotency deals with remembering enqueue requests and dedupe keys so that repeated
+// submissions don't create duplicate tasks
+
+// staleClaim is how long an idempotency key without a response is considered in progress, after that the request
+// is assumed to have died and the key can be claimed again
+const staleClaim = time.Minute
+
+// IdempotentResponse is the stored response of a request made with an idempotency key
+type IdempotentResponse struct {
+	Status   int
+	Response json.RawMessage
+}
+
+// ClaimIdempotencyKey claims the key of the user for a new request unless it was used within the window.
+// When the key is taken the stored response is returned, nil while the first request is still in progress
+func (p *PostgresStore) ClaimIdempotencyKey(createdBy string, key string, window time.Duration) (*IdempotentResponse, bool, error) {
+	now := time.Now().UTC()
+
+	res, err := p.db.Exec(`
+		insert into idempotency_keys (createdBy, idempotencyKey, createdAt) values ($1, $2, $3)
+		on conflict (createdBy, idempotencyKey) do update set createdAt = excluded.createdAt, status = null, response = null
+		where idempotency_keys.createdAt < $4 or (idempotency_keys.response is null and idempotency_keys.createdAt < $5)`,
+		createdBy, key, now, now.Add(-window), now.Add(-staleClaim))
+	if err != nil {
+		return nil, false, err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return nil, false, err
+	}
+
+	if count > 0 {
+		return nil, true, nil
+	}
+
+	var status sql.NullInt64
+	var response []byte
+	err = p.db.QueryRow(
+		"select status, response from idempotency_keys where createdBy = $1 and idempotencyKey = $2",
+		createdBy, key).Scan(&status, &response)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if !status.Valid {
+		return nil, false, nil
+	}
+
+	return &IdempotentResponse{Status: int(status.Int64), Response: response}, false, nil
+}
+
+// SaveIdempotentResponse stores the response of the request which claimed the key so it can be replayed
+func (p *PostgresStore) SaveIdempotentResponse(createdBy string, key string, resp IdempotentResponse) error {
+	_, err := p.db.Exec(
+		"update idempotency_keys set status = $3, response = $4 where createdBy = $1 and idempotencyKey = $2",
+		createdBy, key, resp.Status, resp.Response)
+
+	return err
+}
+
+// ReleaseIdempotencyKey removes the claim of a request which failed so the client can retry it
+func (p *PostgresStore) ReleaseIdempotencyKey(createdBy string, key string) error {
+	_, err := p.db.Exec(
+		"delete from idempotency_keys where createdBy = $1 and idempotencyKey = $2", createdBy, key)
+
+	return err
+}
+
+// CreateDedupedTask creates the task unless a task of the same user with the same dedupe key was created within
+// the window. Returns the id of the task holding the key, which is the id of the new task when it was created
+func (p *PostgresStore) CreateDedupedTask(t *task.Task, window time.Duration) (string, error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	// The task row has to exist before the dedupe row references it, it is rolled back when the key is taken
+	if err := createTask(tx, t); err != nil {
+		return "", err
+	}
+
+	var taskId string
+	err = tx.QueryRow(`
+		insert into task_dedupe (createdBy, dedupeKey, taskId, createdAt) values ($1, $2, $3, $4)
+		on conflict (createdBy, dedupeKey) do update set taskId = excluded.taskId, createdAt = excluded.createdAt
+		where task_dedupe.createdAt < $5
+		returning taskId`,
+		t.CreatedBy, t.DedupeKey, t.Id, t.CreatedAt, t.CreatedAt.Add(-window)).Scan(&taskId)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		err = p.db.QueryRow(
+			"select taskId from task_dedupe where createdBy = $1 and dedupeKey = $2",
+			t.CreatedBy, t.DedupeKey).Scan(&taskId)
+		return taskId, err
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return taskId, tx.Commit()
+}
@@ -0,0 +1,228 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/sinderpl/AsyncTaskProcessor/task"
+)
+
+// Package storage/listTasks deals with searching tasks with filters and cursor pagination
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 500
+)
+
+// TaskSort enum describing the supported orders of the task list
+type TaskSort string
+
+const (
+	SortCreatedAtAsc  TaskSort = "createdAt"
+	SortCreatedAtDesc TaskSort = "-createdAt"
+	SortPriorityAsc   TaskSort = "priority"
+	SortPriorityDesc  TaskSort = "-priority"
+)
+
+// ErrInvalidCursor is returned when the cursor can't be decoded or belongs to a different sort
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// TaskFilter narrows down task queries, zero values are not filtered on
+type TaskFilter struct {
+	Statuses       []task.CurrentStatus
+	TaskType       task.TypeOf
+	Priority       *task.ExecutionPriority
+	CreatedBy      string
+	CreatedAfter   *time.Time
+	CreatedBefore  *time.Time
+	FinishedAfter  *time.Time
+	FinishedBefore *time.Time
+
+	Sort   TaskSort // defaults to newest first
+	Limit  int      // defaults to 50, capped at 500
+	Cursor string   // opaque cursor returned by the previous page
+}
+
+// cursor is the position of the last task of a page, encoded into an opaque string for the client
+type cursor struct {
+	Sort  TaskSort `json:"s"`
+	Value string   `json:"v"`
+	Id    string   `json:"i"`
+}
+
+func (c cursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(encoded string, sort TaskSort) (*cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	c := new(cursor)
+	if err := json.Unmarshal(data, c); err != nil || c.Sort != sort {
+		return nil, ErrInvalidCursor
+	}
+
+	return c, nil
+}
+
+// sortColumn returns the column and direction of the sort
+func (s TaskSort) sortColumn() (string, bool, error) {
+	switch s {
+	case SortCreatedAtAsc:
+		return "createdAt", false, nil
+	case SortCreatedAtDesc:
+		return "createdAt", true, nil
+	case SortPriorityAsc:
+		return "priority", false, nil
+	case SortPriorityDesc:
+		return "priority", true, nil
+	}
+	return "", false, fmt.Errorf("unsupported sort: %s", s)
+}
+
+// cursorValue returns the value of the sort column for the task as stored in the cursor
+func (s TaskSort) cursorValue(t *task.Task) string {
+	if s == SortPriorityAsc || s == SortPriorityDesc {
+		return strconv.Itoa(int(t.Priority))
+	}
+	return t.CreatedAt.Format(time.RFC3339Nano)
+}
+
+// parseCursorValue converts the cursor value back into a query argument
+func (s TaskSort) parseCursorValue(value string) (any, error) {
+	if s == SortPriorityAsc || s == SortPriorityDesc {
+		priority, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		return priority, nil
+	}
+
+	createdAt, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return createdAt, nil
+}
+
+// ListTasks retrieves a page of tasks matching the filter and the cursor of the next page,
+// the next cursor is empty on the last page
+func (p *PostgresStore) ListTasks(filter TaskFilter) ([]*task.Task, string, error) {
+	if filter.Sort == "" {
+		filter.Sort = SortCreatedAtDesc
+	}
+
+	column, descending, err := filter.Sort.sortColumn()
+	if err != nil {
+		return nil, "", err
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+	limit = min(limit, maxListLimit)
+
+	conditions := make([]string, 0)
+	args := make([]any, 0)
+
+	add := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if len(filter.Statuses) > 0 {
+		add("status = any($%d)", pq.Array(filter.Statuses))
+	}
+	if filter.TaskType != "" {
+		add("taskType = $%d", filter.TaskType)
+	}
+	if filter.Priority != nil {
+		add("priority = $%d", *filter.Priority)
+	}
+	if filter.CreatedBy != "" {
+		add("createdBy = $%d", filter.CreatedBy)
+	}
+	if filter.CreatedAfter != nil {
+		add("createdAt >= $%d", *filter.CreatedAfter)
+	}
+	if filter.CreatedBefore != nil {
+		add("createdAt < $%d", *filter.CreatedBefore)
+	}
+	if filter.FinishedAfter != nil {
+		add("finishedAt >= $%d", *filter.FinishedAfter)
+	}
+	if filter.FinishedBefore != nil {
+		add("finishedAt < $%d", *filter.FinishedBefore)
+	}
+
+	// Keyset pagination, the id breaks ties between tasks with the same sort value
+	direction, comparison := "asc", ">"
+	if descending {
+		direction, comparison = "desc", "<"
+	}
+
+	if filter.Cursor != "" {
+		c, err := decodeCursor(filter.Cursor, filter.Sort)
+		if err != nil {
+			return nil, "", err
+		}
+
+		value, err := filter.Sort.parseCursorValue(c.Value)
+		if err != nil {
+			return nil, "", err
+		}
+
+		args = append(args, value, c.Id)
+		conditions = append(conditions,
+			fmt.Sprintf("(%s, id) %s ($%d, $%d)", column, comparison, len(args)-1, len(args)))
+	}
+
+	query := "select " + taskColumns + " from tasks"
+	if len(conditions) > 0 {
+		query += " where " + strings.Join(conditions, " and ")
+	}
+
+	// Fetch one extra row to know whether there is a next page
+	args = append(args, limit+1)
+	query += fmt.Sprintf(" order by %s %s, id %s limit $%d", column, direction, direction, len(args))
+
+	rows, err := p.db.Query(query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	tasks := make([]*task.Task, 0, limit)
+	for rows.Next() {
+		t, err := scanIntoTask(rows)
+		if err != nil {
+			return nil, "", err
+		}
+		tasks = append(tasks, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	if len(tasks) <= limit {
+		return tasks, "", nil
+	}
+
+	tasks = tasks[:limit]
+	last := tasks[len(tasks)-1]
+	next := cursor{Sort: filter.Sort, Value: filter.Sort.cursorValue(last), Id: last.Id}
+
+	return tasks, next.encode(), nil
+}
@@ -0,0 +1 @@
+drop table dead_letters
\ No newline at end of file
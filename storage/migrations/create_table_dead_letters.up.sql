@@ -0,0 +1,14 @@
+CREATE TABLE if NOT EXISTS dead_letters (
+    taskId VARCHAR(100) PRIMARY KEY REFERENCES tasks (id) ON DELETE CASCADE,
+    taskType VARCHAR(30),
+    priority INT,
+    createdBy VARCHAR(30),
+    payload JSONB,
+    error TEXT,
+    retries INT,
+    attempts JSONB,
+    failedAt TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS dead_letters_failedAt_idx ON dead_letters (failedAt);
+CREATE INDEX IF NOT EXISTS dead_letters_taskType_idx ON dead_letters (taskType);
@@ -0,0 +1,2 @@
+drop table task_dedupe;
+drop table idempotency_keys
\ No newline at end of file
@@ -0,0 +1,16 @@
+CREATE TABLE if NOT EXISTS idempotency_keys (
+    createdBy VARCHAR(30),
+    idempotencyKey VARCHAR(255),
+    status INT,
+    response JSONB,
+    createdAt TIMESTAMP,
+    PRIMARY KEY (createdBy, idempotencyKey)
+);
+
+CREATE TABLE if NOT EXISTS task_dedupe (
+    createdBy VARCHAR(30),
+    dedupeKey VARCHAR(255),
+    taskId VARCHAR(100) REFERENCES tasks (id) ON DELETE CASCADE,
+    createdAt TIMESTAMP,
+    PRIMARY KEY (createdBy, dedupeKey)
+);
@@ -0,0 +1 @@
+drop table pauses
\ No newline at end of file
@@ -0,0 +1,6 @@
+CREATE TABLE if NOT EXISTS pauses (
+    scope VARCHAR(20),
+    name VARCHAR(100),
+    pausedAt TIMESTAMP,
+    PRIMARY KEY (scope, name)
+);
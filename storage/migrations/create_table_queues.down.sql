@@ -0,0 +1 @@
+drop table queues
\ No newline at end of file
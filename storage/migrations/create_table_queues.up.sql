@@ -0,0 +1,9 @@
+CREATE TABLE if NOT EXISTS queues (
+    name VARCHAR(100) PRIMARY KEY,
+    maxBufferSize INT NOT NULL DEFAULT 0,
+    priorityLevels INT NOT NULL DEFAULT 0,
+    priorityWeights INT[] NOT NULL DEFAULT '{}',
+    workerPoolSize INT NOT NULL DEFAULT 0,
+    taskTypes TEXT[] NOT NULL DEFAULT '{}',
+    createdAt TIMESTAMP
+);
@@ -0,0 +1,17 @@
+CREATE TABLE if NOT EXISTS schedules (
+    id VARCHAR(100) PRIMARY KEY,
+    cron VARCHAR(100),
+    timezone VARCHAR(60),
+    taskType VARCHAR(30),
+    priority INT,
+    payloadTemplate TEXT,
+    timeout VARCHAR(30),
+    maxRetries INT,
+    status VARCHAR(30),
+    nextRunAt TIMESTAMP,
+    lastRunAt TIMESTAMP,
+    createdAt TIMESTAMP,
+    createdBy VARCHAR(30)
+);
+
+CREATE INDEX IF NOT EXISTS schedules_nextRunAt_idx ON schedules (nextRunAt) WHERE status = 'Active';
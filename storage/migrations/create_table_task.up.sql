@@ -10,4 +10,35 @@ CREATE TABLE if NOT EXISTS tasks (
     startedAt  TIMESTAMP,
     finishedAt  TIMESTAMP,
     error VARCHAR(100)
-)
\ No newline at end of file
+);
+
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS timeout BIGINT;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS retries INT DEFAULT 0;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS backOffUntil TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS tasks_status_idx ON tasks (status);
+
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS backOffPolicy JSONB;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS lastBackOff BIGINT DEFAULT 0;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS maxRetries INT;
+
+CREATE INDEX IF NOT EXISTS tasks_taskType_idx ON tasks (taskType);
+CREATE INDEX IF NOT EXISTS tasks_createdBy_idx ON tasks (createdBy);
+CREATE INDEX IF NOT EXISTS tasks_createdAt_id_idx ON tasks (createdAt, id);
+CREATE INDEX IF NOT EXISTS tasks_priority_id_idx ON tasks (priority, id);
+CREATE INDEX IF NOT EXISTS tasks_finishedAt_idx ON tasks (finishedAt);
+
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS workflowId VARCHAR(100) NOT NULL DEFAULT '';
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS taskKey VARCHAR(100) NOT NULL DEFAULT '';
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS dependsOn TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE INDEX IF NOT EXISTS tasks_workflowId_idx ON tasks (workflowId) WHERE workflowId <> '';
+ALTER TABLE tasks ALTER COLUMN error TYPE TEXT;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS result JSONB;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS dedupeKey VARCHAR(255) NOT NULL DEFAULT '';
+
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS uniqueKey VARCHAR(255) NOT NULL DEFAULT '';
+CREATE UNIQUE INDEX IF NOT EXISTS tasks_unique_pending_idx ON tasks (taskType, uniqueKey)
+    WHERE uniqueKey <> '' AND status NOT IN ('Processed successfully', 'Failed to process', 'Cancelled', 'Skipped, dependency did not succeed');
+
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS queue VARCHAR(100) NOT NULL DEFAULT '';
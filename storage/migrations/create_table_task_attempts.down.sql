@@ -0,0 +1 @@
+drop table task_attempts
\ No newline at end of file
@@ -0,0 +1,10 @@
+CREATE TABLE if NOT EXISTS task_attempts (
+    taskId VARCHAR(100) REFERENCES tasks (id) ON DELETE CASCADE,
+    attempt INT,
+    workerId VARCHAR(100),
+    startedAt TIMESTAMP,
+    finishedAt TIMESTAMP,
+    durationMs BIGINT,
+    error TEXT,
+    PRIMARY KEY (taskId, attempt)
+);
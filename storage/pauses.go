@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"time"
+)
+
+// Package storage/pauses deals with persisting the paused queues and task types so a restart does not resume them
+
+// PauseScope describes what a pause applies to
+type PauseScope string
+
+const (
+	PauseScopeQueue    PauseScope = "queue"
+	PauseScopeTaskType PauseScope = "taskType"
+)
+
+// Pause is a paused queue or task type
+type Pause struct {
+	Scope    PauseScope `json:"scope"`
+	Name     string     `json:"name"`
+	PausedAt time.Time  `json:"pausedAt"`
+}
+
+// CreatePause stores the pause, pausing what is already paused keeps the original pause
+func (p *PostgresStore) CreatePause(pause *Pause) error {
+	_, err := p.db.Exec(`
+		insert into pauses (scope, name, pausedAt) values ($1, $2, $3)
+		on conflict (scope, name) do nothing`,
+		pause.Scope, pause.Name, pause.PausedAt)
+
+	return err
+}
+
+// DeletePause removes the pause. Returns false when it was not paused
+func (p *PostgresStore) DeletePause(scope PauseScope, name string) (bool, error) {
+	res, err := p.db.Exec("delete from pauses where scope = $1 and name = $2", scope, name)
+	if err != nil {
+		return false, err
+	}
+
+	count, err := res.RowsAffected()
+
+	return count > 0, err
+}
+
+// ListPauses returns every pause in the order they were paused
+func (p *PostgresStore) ListPauses() ([]*Pause, error) {
+	rows, err := p.db.Query("select scope, name, pausedAt from pauses order by pausedAt")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pauses := make([]*Pause, 0)
+	for rows.Next() {
+		pause := new(Pause)
+		if err := rows.Scan(&pause.Scope, &pause.Name, &pause.PausedAt); err != nil {
+			return nil, err
+		}
+		pauses = append(pauses, pause)
+	}
+
+	return pauses, rows.Err()
+}
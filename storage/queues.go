@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/sinderpl/AsyncTaskProcessor/task"
+)
+
+// Package storage/queues deals with persisting the named queues created at runtime so they survive a restart
+
+// QueueConfig describes a named queue, zero values fall back to the defaults of the queue
+type QueueConfig struct {
+	Name            string        `json:"name"`
+	MaxBufferSize   int           `json:"maxBufferSize,omitempty"`
+	PriorityLevels  int           `json:"priorityLevels,omitempty"`
+	PriorityWeights []int         `json:"priorityWeights,omitempty"`
+	WorkerPoolSize  int           `json:"workerPoolSize,omitempty"`
+	TaskTypes       []task.TypeOf `json:"taskTypes,omitempty"` // task types routed to the queue
+	CreatedAt       time.Time     `json:"createdAt"`
+}
+
+// CreateQueueConfig stores the config of a queue created at runtime
+func (p *PostgresStore) CreateQueueConfig(q *QueueConfig) error {
+	weights := make([]int64, len(q.PriorityWeights))
+	for i, weight := range q.PriorityWeights {
+		weights[i] = int64(weight)
+	}
+
+	_, err := p.db.Exec(`
+		insert into queues (name, maxBufferSize, priorityLevels, priorityWeights, workerPoolSize, taskTypes, createdAt)
+		values ($1, $2, $3, $4, $5, $6, $7)`,
+		q.Name, q.MaxBufferSize, q.PriorityLevels, pq.Array(weights), q.WorkerPoolSize, pq.Array(q.TaskTypes), q.CreatedAt)
+
+	return err
+}
+
+// ListQueueConfigs returns the configs of the queues created at runtime in the order they were created
+func (p *PostgresStore) ListQueueConfigs() ([]*QueueConfig, error) {
+	rows, err := p.db.Query(`
+		select name, maxBufferSize, priorityLevels, priorityWeights, workerPoolSize, taskTypes, createdAt
+		from queues order by createdAt`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	configs := make([]*QueueConfig, 0)
+	for rows.Next() {
+		q := new(QueueConfig)
+		var weights []int64
+		var taskTypes []string
+
+		err := rows.Scan(&q.Name, &q.MaxBufferSize, &q.PriorityLevels, pq.Array(&weights), &q.WorkerPoolSize,
+			pq.Array(&taskTypes), &q.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, weight := range weights {
+			q.PriorityWeights = append(q.PriorityWeights, int(weight))
+		}
+		for _, typeOf := range taskTypes {
+			q.TaskTypes = append(q.TaskTypes, task.TypeOf(typeOf))
+		}
+
+		configs = append(configs, q)
+	}
+
+	return configs, rows.Err()
+}
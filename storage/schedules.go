@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/sinderpl/AsyncTaskProcessor/schedule"
+	"github.com/sinderpl/AsyncTaskProcessor/task"
+)
+
+// Package storage/schedules deals with persisting recurring schedules and claiming their runs
+
+const scheduleColumns = `id, cron, timezone, taskType, priority, payloadTemplate, timeout, maxRetries, status,
+	nextRunAt, lastRunAt, createdAt, createdBy`
+
+// CreateSchedule creates the schedule row in the database
+func (p *PostgresStore) CreateSchedule(s *schedule.Schedule) error {
+	query := `
+		insert into schedules (` + scheduleColumns + `)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		`
+
+	_, err := p.db.Exec(query, s.Id, s.Cron, s.Timezone, s.TaskType, s.Priority, s.PayloadTemplate, s.Timeout,
+		s.MaxRetries, s.Status, s.NextRunAt, s.LastRunAt, s.CreatedAt, s.CreatedBy)
+
+	return err
+}
+
+// UpdateSchedule saves the status and run times of the schedule
+func (p *PostgresStore) UpdateSchedule(s *schedule.Schedule) error {
+	_, err := p.db.Exec(
+		"update schedules set status = $2, nextRunAt = $3, lastRunAt = $4 where id = $1",
+		s.Id, s.Status, s.NextRunAt, s.LastRunAt)
+
+	return err
+}
+
+// GetScheduleById retrieves the schedule, nil when it does not exist
+func (p *PostgresStore) GetScheduleById(id string) (*schedule.Schedule, error) {
+	schedules, err := p.querySchedules("select "+scheduleColumns+" from schedules where id = $1", id)
+	if err != nil || len(schedules) == 0 {
+		return nil, err
+	}
+
+	return schedules[0], nil
+}
+
+// ListSchedules retrieves every schedule, oldest first
+func (p *PostgresStore) ListSchedules() ([]*schedule.Schedule, error) {
+	return p.querySchedules("select " + scheduleColumns + " from schedules order by createdAt")
+}
+
+// DeleteSchedule removes the schedule, the tasks it already created are kept. Returns false when it did not exist
+func (p *PostgresStore) DeleteSchedule(id string) (bool, error) {
+	res, err := p.db.Exec("delete from schedules where id = $1", id)
+	if err != nil {
+		return false, err
+	}
+
+	count, err := res.RowsAffected()
+
+	return count > 0, err
+}
+
+// GetDueSchedules retrieves the active schedules whose next run is due
+func (p *PostgresStore) GetDueSchedules(now time.Time) ([]*schedule.Schedule, error) {
+	return p.querySchedules(
+		"select "+scheduleColumns+" from schedules where status = $1 and nextRunAt <= $2 order by nextRunAt",
+		schedule.Active, now)
+}
+
+// FireSchedule moves the schedule on to its next run only if its stored next run is still runAt and creates
+// the task of the run in the same transaction, so each run creates exactly one task even across restarts.
+// A run whose task has a unique key which is still pending is claimed without creating a task and returns false
+func (p *PostgresStore) FireSchedule(s *schedule.Schedule, runAt time.Time, t *task.Task) (bool, error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		update schedules set nextRunAt = $3, lastRunAt = $4
+		where id = $1 and status = $2 and nextRunAt = $4`,
+		s.Id, schedule.Active, s.NextRunAt, runAt)
+	if err != nil {
+		return false, err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil || count == 0 {
+		return false, err
+	}
+
+	if t == nil {
+		return true, tx.Commit()
+	}
+
+	// A run whose work is still pending from an earlier run is skipped, the schedule still moves on
+	if _, err := tx.Exec("savepoint fire_task"); err != nil {
+		return false, err
+	}
+
+	err = createTask(tx, t)
+	if errors.Is(err, ErrUniqueTaskPending) {
+		if _, err := tx.Exec("rollback to savepoint fire_task"); err != nil {
+			return false, err
+		}
+		return false, tx.Commit()
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+func (p *PostgresStore) querySchedules(query string, args ...any) ([]*schedule.Schedule, error) {
+	rows, err := p.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := make([]*schedule.Schedule, 0)
+	for rows.Next() {
+		s, err := scanIntoSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, s)
+	}
+
+	return schedules, rows.Err()
+}
+
+func scanIntoSchedule(rows *sql.Rows) (*schedule.Schedule, error) {
+	s := new(schedule.Schedule)
+
+	err := rows.Scan(&s.Id, &s.Cron, &s.Timezone, &s.TaskType, &s.Priority, &s.PayloadTemplate, &s.Timeout,
+		&s.MaxRetries, &s.Status, &s.NextRunAt, &s.LastRunAt, &s.CreatedAt, &s.CreatedBy)
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
@@ -2,14 +2,17 @@ package storage
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"log/slog"
 	"os"
+	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+	"github.com/sinderpl/AsyncTaskProcessor/schedule"
 	"github.com/sinderpl/AsyncTaskProcessor/task"
 )
 
@@ -17,10 +20,56 @@ import (
 
 type Storage interface {
 	CreateTask(*task.Task) error
+	CreateDedupedTask(*task.Task, time.Duration) (string, error)
+	GetPendingUniqueTask(task.TypeOf, string) (*task.Task, error)
 	UpdateTask(*task.Task) error
+	UpdateTaskFrom(*task.Task, task.CurrentStatus) error
+	UpdateTaskUnlessCancelled(*task.Task) error
+	UpdateTaskStatus(string, task.CurrentStatus) error
 	GetTaskById(string) (*task.Task, error)
+	GetUnfinishedTasks() ([]*task.Task, error)
+	GetWorkflowTasks(string) ([]*task.Task, error)
+	ListTasks(TaskFilter) ([]*task.Task, string, error)
+
+	CreateAttempt(string, task.Attempt) error
+	GetTaskAttempts(string) ([]task.Attempt, error)
+
+	CreateDeadLetter(*DeadLetter) error
+	ListDeadLetters(DeadLetterFilter) ([]*DeadLetter, error)
+	DeleteDeadLetters(DeadLetterFilter) (int64, error)
+
+	CreateSchedule(*schedule.Schedule) error
+	UpdateSchedule(*schedule.Schedule) error
+	GetScheduleById(string) (*schedule.Schedule, error)
+	ListSchedules() ([]*schedule.Schedule, error)
+	DeleteSchedule(string) (bool, error)
+	GetDueSchedules(time.Time) ([]*schedule.Schedule, error)
+	FireSchedule(*schedule.Schedule, time.Time, *task.Task) (bool, error)
+
+	ClaimIdempotencyKey(string, string, time.Duration) (*IdempotentResponse, bool, error)
+	SaveIdempotentResponse(string, string, IdempotentResponse) error
+	ReleaseIdempotencyKey(string, string) error
+
+	CreateQueueConfig(*QueueConfig) error
+	ListQueueConfigs() ([]*QueueConfig, error)
+
+	CreatePause(*Pause) error
+	DeletePause(PauseScope, string) (bool, error)
+	ListPauses() ([]*Pause, error)
 }
 
+// taskColumns lists the task columns in the order scanIntoTask expects them, new columns are appended by the
+// migrations so select * can't be relied on for the order
+const taskColumns = `id, priority, taskType, status, backOffDuration, payload, createdAt, createdBy, startedAt,
+	finishedAt, error, timeout, retries, backOffUntil, backOffPolicy, lastBackOff,
+	maxRetries, workflowId, taskKey, dependsOn, result, uniqueKey, queue`
+
+// ErrTaskStatusChanged is returned by UpdateTaskFrom when the stored task is no longer in the expected status
+var ErrTaskStatusChanged = errors.New("task status changed")
+
+// ErrTaskCancelled is returned by UpdateTaskUnlessCancelled when the stored task was cancelled
+var ErrTaskCancelled = errors.New("task cancelled")
+
 // PostgresStore stores basic postgres sql data
 type PostgresStore struct {
 	db   *sql.DB
@@ -32,16 +81,60 @@ func (p *PostgresStore) Init() error {
 	return p.applyMigrations()
 }
 
-func (p *PostgresStore) applyMigrations() error{
-	err := p.apply("storage/migrations/create_table_task.up.sql")
+func (p *PostgresStore) applyMigrations() error {
+	if err := p.apply("storage/migrations/create_table_task.up.sql"); err != nil {
+		return err
+	}
+
+	if err := p.apply("storage/migrations/create_table_task_attempts.up.sql"); err != nil {
+		return err
+	}
+
+	if err := p.apply("storage/migrations/create_table_dead_letters.up.sql"); err != nil {
+		return err
+	}
+
+	if err := p.apply("storage/migrations/create_table_schedules.up.sql"); err != nil {
+		return err
+	}
+
+	if err := p.apply("storage/migrations/create_table_idempotency.up.sql"); err != nil {
+		return err
+	}
 
-	return err
+	if err := p.apply("storage/migrations/create_table_queues.up.sql"); err != nil {
+		return err
+	}
+
+	return p.apply("storage/migrations/create_table_pauses.up.sql")
 }
 
-func (p *PostgresStore) removeMigrations() error{
-	err := p.apply("storage/migrations/create_table_task.down.sql")
+func (p *PostgresStore) removeMigrations() error {
+	if err := p.apply("storage/migrations/create_table_pauses.down.sql"); err != nil {
+		return err
+	}
+
+	if err := p.apply("storage/migrations/create_table_queues.down.sql"); err != nil {
+		return err
+	}
+
+	if err := p.apply("storage/migrations/create_table_idempotency.down.sql"); err != nil {
+		return err
+	}
+
+	if err := p.apply("storage/migrations/create_table_schedules.down.sql"); err != nil {
+		return err
+	}
+
+	if err := p.apply("storage/migrations/create_table_dead_letters.down.sql"); err != nil {
+		return err
+	}
+
+	if err := p.apply("storage/migrations/create_table_task_attempts.down.sql"); err != nil {
+		return err
+	}
 
-	return err
+	return p.apply("storage/migrations/create_table_task.down.sql")
 }
 
 func (p *PostgresStore) apply(name string) error {
@@ -52,7 +145,7 @@ func (p *PostgresStore) apply(name string) error {
 
 	defer file.Close()
 
-	buf,err := io.ReadAll(file)
+	buf, err := io.ReadAll(file)
 	if err != nil {
 		return err
 	}
@@ -85,16 +178,36 @@ func NewPostgresStore(host string, user string, dbname string, password string)
 	}, nil
 }
 
+// Close closes the database connection
+func (p *PostgresStore) Close() error {
+	return p.db.Close()
+}
+
+// execer is implemented by both the database and a transaction so rows can be written in either
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
 // CreateTask creates the task row in the database
 func (p *PostgresStore) CreateTask(t *task.Task) error {
+	return createTask(p.db, t)
+}
+
+func createTask(db execer, t *task.Task) error {
+	policy, err := marshalNullable(t.BackOffPolicy)
+	if err != nil {
+		return err
+	}
+
 	query := `
 		insert into tasks
-		(id, priority, taskType, status, backOffDuration, payload, createdAt, createdBy, error)
-		values ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		(id, priority, taskType, status, backOffDuration, payload, createdAt, createdBy, error, timeout, backOffPolicy,
+		 maxRetries, backOffUntil, workflowId, taskKey, dependsOn, dedupeKey, uniqueKey, queue)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)
 		returning id
 		`
 
-	_, err := p.db.Exec(
+	_, err = db.Exec(
 		query,
 		t.Id,
 		t.Priority,
@@ -104,9 +217,22 @@ func (p *PostgresStore) CreateTask(t *task.Task) error {
 		t.Payload,
 		t.CreatedAt,
 		t.CreatedBy,
-		t.ErrorDetails)
+		t.ErrorDetails,
+		t.Timeout,
+		policy,
+		t.MaxRetries,
+		t.BackOffUntil,
+		t.WorkflowId,
+		t.Key,
+		pq.Array(t.DependsOn),
+		t.DedupeKey,
+		t.UniqueKey,
+		t.Queue)
 
 	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrUniqueTaskPending
+		}
 		slog.Error(err.Error())
 		return err
 	}
@@ -115,22 +241,94 @@ func (p *PostgresStore) CreateTask(t *task.Task) error {
 
 // UpdateTask takes in task id and attempts to update the row in the database
 func (p *PostgresStore) UpdateTask(t *task.Task) error {
+	count, err := p.updateTask(t, "")
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return errors.New("failed to find and update task id in database")
+	}
+
+	return nil
+}
+
+// UpdateTaskFrom updates the task like UpdateTask only while its stored status is still the given one, a task whose
+// status was changed concurrently is left untouched and ErrTaskStatusChanged is returned
+func (p *PostgresStore) UpdateTaskFrom(t *task.Task, from task.CurrentStatus) error {
+	count, err := p.updateTask(t, " AND status = $14", from)
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return ErrTaskStatusChanged
+	}
+
+	return nil
+}
+
+// UpdateTaskUnlessCancelled updates the task like UpdateTask unless it was cancelled in the meantime, the cancelled
+// task is left untouched and ErrTaskCancelled is returned
+func (p *PostgresStore) UpdateTaskUnlessCancelled(t *task.Task) error {
+	count, err := p.updateTask(t, " AND status <> $14", task.ProcessingCancelled)
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return ErrTaskCancelled
+	}
+
+	return nil
+}
+
+// updateTask saves the task where the condition holds and returns how many rows were updated, the condition
+// arguments are numbered after the task columns
+func (p *PostgresStore) updateTask(t *task.Task, condition string, conditionArgs ...any) (int64, error) {
+	policy, err := marshalNullable(t.BackOffPolicy)
+	if err != nil {
+		return 0, err
+	}
 
 	// Prepare the SQL update statement
 	sqlStatement := `
         UPDATE tasks
-        SET status = $2, startedAt = $3, finishedAt = $4, error = $5
-        WHERE id = $1;`
+        SET status = $2, startedAt = $3, finishedAt = $4, error = $5, timeout = $6, retries = $7, backOffUntil = $8,
+            backOffPolicy = $9, lastBackOff = $10, payload = $11, result = $12, uniqueKey = $13
+        WHERE id = $1` + condition
+
+	args := []any{t.Id, t.Status, t.StartedAt, t.FinishedAt, t.ErrorDetails, t.Timeout, t.Retries, t.BackOffUntil,
+		policy, t.LastBackOff, t.Payload, t.Result, t.UniqueKey}
 
 	// Execute the update statement
-	res, err := p.db.Exec(sqlStatement, t.Id, t.Status, t.StartedAt, t.FinishedAt, t.ErrorDetails)
+	res, err := p.db.Exec(sqlStatement, append(args, conditionArgs...)...)
 	if err != nil {
+		// A finished task brought back while another task with its unique key is pending
+		if isUniqueViolation(err) {
+			return 0, ErrUniqueTaskPending
+		}
 		log.Fatal(err)
 	}
 
 	count, err := res.RowsAffected()
 	if err != nil {
-		slog.Error("error while writing task to database: ", err)
+		slog.Error(fmt.Sprintf("error while writing task to database: %v", err))
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// UpdateTaskStatus sets only the status of the task, used when the rest of the task is owned by a worker
+func (p *PostgresStore) UpdateTaskStatus(id string, status task.CurrentStatus) error {
+	res, err := p.db.Exec("UPDATE tasks SET status = $2 WHERE id = $1", id, status)
+	if err != nil {
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
 		return err
 	}
 
@@ -143,11 +341,12 @@ func (p *PostgresStore) UpdateTask(t *task.Task) error {
 
 // GetTaskById retrieves the task info from the database
 func (p *PostgresStore) GetTaskById(id string) (*task.Task, error) {
-	rows, err := p.db.Query("select * from tasks where id = $1", id)
+	rows, err := p.db.Query("select "+taskColumns+" from tasks where id = $1", id)
 
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		return scanIntoTask(rows)
@@ -156,8 +355,41 @@ func (p *PostgresStore) GetTaskById(id string) (*task.Task, error) {
 	return nil, fmt.Errorf("task %s not found", id)
 }
 
+// GetUnfinishedTasks retrieves every task which has not reached a final status so it can be recovered
+func (p *PostgresStore) GetUnfinishedTasks() ([]*task.Task, error) {
+	rows, err := p.db.Query(
+		"select "+taskColumns+" from tasks where status = any($1) order by priority desc, createdAt",
+		pq.Array([]task.CurrentStatus{
+			task.ProcessingAwaiting,
+			task.ProcessingScheduled,
+			task.ProcessingBlocked,
+			task.ProcessingRateLimited,
+			task.ProcessingEnqueued,
+			task.Processing,
+			task.ProcessingAwaitingRetry,
+			task.ProcessingTimedOut,
+		}))
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tasks := make([]*task.Task, 0)
+	for rows.Next() {
+		t, err := scanIntoTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, t)
+	}
+
+	return tasks, rows.Err()
+}
+
 func scanIntoTask(rows *sql.Rows) (*task.Task, error) {
 	t := new(task.Task)
+	var policy, result []byte
 
 	err := rows.Scan(
 		&t.Id,
@@ -170,16 +402,56 @@ func scanIntoTask(rows *sql.Rows) (*task.Task, error) {
 		&t.CreatedBy,
 		&t.StartedAt,
 		&t.FinishedAt,
-		&t.ErrorDetails)
+		&t.ErrorDetails,
+		&t.Timeout,
+		&t.Retries,
+		&t.BackOffUntil,
+		&policy,
+		&t.LastBackOff,
+		&t.MaxRetries,
+		&t.WorkflowId,
+		&t.Key,
+		pq.Array(&t.DependsOn),
+		&result,
+		&t.UniqueKey,
+		&t.Queue)
 
 	if err != nil {
 		return nil, err
 	}
 
+	if len(policy) > 0 {
+		t.BackOffPolicy = new(task.BackoffPolicy)
+		if err := json.Unmarshal(policy, t.BackOffPolicy); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal backoff policy of task %s: %v", t.Id, err)
+		}
+	}
+
+	if len(result) > 0 {
+		t.Result = result
+	}
+
 	// Not ideal but I needed a quick workaround to save in case task has an error
 	if t.ErrorDetails != "" {
 		t.Error = errors.New(t.ErrorDetails)
 	}
 
+	// Rehydrate the concrete implementation through the task registry, a type that is no longer registered
+	// still returns the stored row so that it can be inspected
+	processable, err := t.ParseTaskType()
+	if err != nil {
+		slog.Warn(fmt.Sprintf("failed to rehydrate task %s of type %s: %v", t.Id, t.TaskType, err))
+	} else {
+		t.ProcessableTask = processable
+	}
+
 	return t, nil
 }
+
+// marshalNullable marshals the value into json, nil pointers are stored as NULL
+func marshalNullable[T any](v *T) ([]byte, error) {
+	if v == nil {
+		return nil, nil
+	}
+	return json.Marshal(v)
+}
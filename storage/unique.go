@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"errors"
+
+	"github.com/lib/pq"
+	"github.com/sinderpl/AsyncTaskProcessor/task"
+)
+
+// Package storage/unique deals with keeping a single pending task per unique key of a task type
+
+// uniquePendingIndex is the partial unique index on the unique key of the tasks which have not finished yet
+const uniquePendingIndex = "tasks_unique_pending_idx"
+
+// ErrUniqueTaskPending is returned when a task of the same type with the same unique key is already pending
+var ErrUniqueTaskPending = errors.New("a task with the same unique key is already pending")
+
+// isUniqueViolation reports whether the error is a violation of the unique key of the pending tasks
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == uniquePendingIndex
+}
+
+// GetPendingUniqueTask returns the unfinished task of the type with the unique key, nil when there is none
+func (p *PostgresStore) GetPendingUniqueTask(taskType task.TypeOf, uniqueKey string) (*task.Task, error) {
+	rows, err := p.db.Query(
+		"select "+taskColumns+" from tasks where taskType = $1 and uniqueKey = $2 and status <> all($3)",
+		taskType, uniqueKey, pq.Array(finalStatuses))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+
+	return scanIntoTask(rows)
+}
+
+// finalStatuses are the statuses of the tasks which no longer hold their unique key, they match the predicate
+// of the unique index
+var finalStatuses = []task.CurrentStatus{
+	task.ProcessingSuccess,
+	task.ProcessingFailed,
+	task.ProcessingCancelled,
+	task.ProcessingSkipped,
+}
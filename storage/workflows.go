@@ -0,0 +1,28 @@
+package storage
+
+import (
+	"github.com/sinderpl/AsyncTaskProcessor/task"
+)
+
+// Package storage/workflows deals with reading the tasks of a workflow
+
+// GetWorkflowTasks retrieves every task of the workflow in the order they were submitted
+func (p *PostgresStore) GetWorkflowTasks(workflowId string) ([]*task.Task, error) {
+	rows, err := p.db.Query(
+		"select "+taskColumns+" from tasks where workflowId = $1 order by createdAt, id", workflowId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tasks := make([]*task.Task, 0)
+	for rows.Next() {
+		t, err := scanIntoTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, t)
+	}
+
+	return tasks, rows.Err()
+}
@@ -0,0 +1,153 @@
+package task
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// BackoffStrategy enum describing how the wait between retries grows
+type BackoffStrategy string
+
+const (
+	BackoffConstant           BackoffStrategy = "constant"           // always waits base
+	BackoffLinear             BackoffStrategy = "linear"             // waits base * retry
+	BackoffExponential        BackoffStrategy = "exponential"        // waits base * multiplier^(retry-1)
+	BackoffFullJitter         BackoffStrategy = "fullJitter"         // waits a random duration up to the exponential one
+	BackoffDecorrelatedJitter BackoffStrategy = "decorrelatedJitter" // waits a random duration between base and 3x the previous wait
+)
+
+const defaultBackoffMultiplier = 2
+
+// BackoffPolicy describes how long a task waits before each retry, computed from the amount of retries so far
+type BackoffPolicy struct {
+	Strategy   BackoffStrategy
+	Base       time.Duration
+	Multiplier float64       // growth factor of the exponential strategies, defaults to 2
+	Max        time.Duration // caps the wait, 0 means no cap
+}
+
+// backoffPolicyJSON is the wire format of the policy with durations written as strings e.g. "5s"
+type backoffPolicyJSON struct {
+	Strategy   BackoffStrategy `json:"strategy"`
+	Base       string          `json:"base"`
+	Multiplier float64         `json:"multiplier,omitempty"`
+	Max        string          `json:"max,omitempty"`
+}
+
+// ParseBackoffPolicy creates a policy from its string representation as used in the config and requests
+func ParseBackoffPolicy(strategy BackoffStrategy, base string, multiplier float64, maxWait string) (*BackoffPolicy, error) {
+	p := &BackoffPolicy{
+		Strategy:   strategy,
+		Multiplier: multiplier,
+	}
+
+	var err error
+	if p.Base, err = time.ParseDuration(base); err != nil {
+		return nil, fmt.Errorf("invalid backoff base: %v", err)
+	}
+
+	if maxWait != "" {
+		if p.Max, err = time.ParseDuration(maxWait); err != nil {
+			return nil, fmt.Errorf("invalid backoff max: %v", err)
+		}
+	}
+
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (p BackoffPolicy) MarshalJSON() ([]byte, error) {
+	out := backoffPolicyJSON{
+		Strategy:   p.Strategy,
+		Base:       p.Base.String(),
+		Multiplier: p.Multiplier,
+	}
+	if p.Max > 0 {
+		out.Max = p.Max.String()
+	}
+	return json.Marshal(out)
+}
+
+func (p *BackoffPolicy) UnmarshalJSON(data []byte) error {
+	var in backoffPolicyJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+
+	parsed, err := ParseBackoffPolicy(in.Strategy, in.Base, in.Multiplier, in.Max)
+	if err != nil {
+		return err
+	}
+
+	*p = *parsed
+	return nil
+}
+
+// Validate validates the strategy is supported and the durations make sense
+func (p *BackoffPolicy) Validate() error {
+	switch p.Strategy {
+	case BackoffConstant, BackoffLinear, BackoffExponential, BackoffFullJitter, BackoffDecorrelatedJitter:
+	default:
+		return fmt.Errorf("unsupported backoff strategy: %s", p.Strategy)
+	}
+
+	if p.Base <= 0 {
+		return fmt.Errorf("backoff base must be positive")
+	}
+
+	if p.Multiplier < 0 || (p.Multiplier > 0 && p.Multiplier < 1) {
+		return fmt.Errorf("backoff multiplier must be at least 1")
+	}
+
+	if p.Max < 0 || (p.Max > 0 && p.Max < p.Base) {
+		return fmt.Errorf("backoff max must not be lower than base")
+	}
+
+	return nil
+}
+
+// Next returns the wait before the given retry, starting from 1. previous is the wait before the last retry
+// and is only used by the decorrelated jitter strategy
+func (p *BackoffPolicy) Next(retry int, previous time.Duration) time.Duration {
+	retry = max(retry, 1)
+
+	multiplier := p.Multiplier
+	if multiplier == 0 {
+		multiplier = defaultBackoffMultiplier
+	}
+
+	var wait float64
+	switch p.Strategy {
+	case BackoffLinear:
+		wait = float64(p.Base) * float64(retry)
+	case BackoffExponential:
+		wait = float64(p.Base) * math.Pow(multiplier, float64(retry-1))
+	case BackoffFullJitter:
+		wait = rand.Float64() * float64(p.capped(float64(p.Base)*math.Pow(multiplier, float64(retry-1))))
+	case BackoffDecorrelatedJitter:
+		upper := float64(max(previous, p.Base)) * 3
+		wait = float64(p.Base) + rand.Float64()*(upper-float64(p.Base))
+	default:
+		wait = float64(p.Base)
+	}
+
+	return p.capped(wait)
+}
+
+// capped limits the wait to the max and to what fits in a duration, float64(math.MaxInt64) itself does not fit
+// so the comparison has to include it
+func (p *BackoffPolicy) capped(wait float64) time.Duration {
+	if p.Max > 0 && wait > float64(p.Max) {
+		return p.Max
+	}
+	if wait >= float64(math.MaxInt64) {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(wait)
+}
@@ -0,0 +1,35 @@
+package task
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestBackoffPolicyNextLargeRetry(t *testing.T) {
+	policies := []*BackoffPolicy{
+		{Strategy: BackoffExponential, Base: time.Second},
+		{Strategy: BackoffLinear, Base: time.Duration(math.MaxInt64 / 2)},
+		{Strategy: BackoffFullJitter, Base: time.Second},
+	}
+
+	for _, policy := range policies {
+		for _, retry := range []int{64, 1000, math.MaxInt32} {
+			wait := policy.Next(retry, 0)
+			if wait < 0 {
+				t.Errorf("%s retry %d: wait overflowed to %v", policy.Strategy, retry, wait)
+			}
+			if policy.Strategy != BackoffFullJitter && wait != time.Duration(math.MaxInt64) {
+				t.Errorf("%s retry %d: got %v, want the longest duration", policy.Strategy, retry, wait)
+			}
+		}
+	}
+}
+
+func TestBackoffPolicyNextCappedByMax(t *testing.T) {
+	policy := &BackoffPolicy{Strategy: BackoffExponential, Base: time.Second, Max: time.Minute}
+
+	if wait := policy.Next(1000, 0); wait != time.Minute {
+		t.Errorf("got %v, want %v", wait, time.Minute)
+	}
+}
@@ -0,0 +1,31 @@
+package task
+
+import "errors"
+
+// PermanentError marks a processing error which can't be fixed by retrying e.g. an invalid recipient,
+// a task failing with it skips the remaining retries and goes straight to failed
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent wraps the error to mark it as non-retryable, a nil error stays nil
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{Err: err}
+}
+
+// IsPermanent reports whether any error in the chain was marked as non-retryable
+func IsPermanent(err error) bool {
+	var permanent *PermanentError
+	return errors.As(err, &permanent)
+}
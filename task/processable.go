@@ -0,0 +1,70 @@
+package task
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// ContextProcessable is implemented by tasks which support cancellation, the context passed in carries the
+// execution deadline of the task and is cancelled on shutdown so long-running work should check it regularly
+type ContextProcessable interface {
+	ProcessTaskContext(ctx context.Context) error
+	ValidateTask() error
+}
+
+// processableAdapter wraps the legacy Processable interface so it can be run as a ContextProcessable
+type processableAdapter struct {
+	Processable
+}
+
+// AdaptProcessable wraps a legacy Processable implementation into a ContextProcessable.
+// The legacy implementation can't be interrupted, so when the context is done the worker is released while the
+// task keeps running in the background until it returns. A timed out legacy task is retried like any other, the
+// retry can overlap with the attempt which is still running so legacy tasks should be safe to run twice
+func AdaptProcessable(p Processable) ContextProcessable {
+	return &processableAdapter{Processable: p}
+}
+
+// ProcessTaskContext runs the wrapped task and returns early with the context error if the context is done first
+func (a *processableAdapter) ProcessTaskContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- a.ProcessTask()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// UnmarshalJSON unmarshals the payload into the wrapped implementation
+func (a *processableAdapter) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, a.Processable)
+}
+
+// Unwrap returns the wrapped legacy implementation
+func (a *processableAdapter) Unwrap() Processable {
+	return a.Processable
+}
+
+// implements returns the implementation as T, looking through the adapter for legacy implementations
+func implements[T any](p ContextProcessable) (T, bool) {
+	if impl, ok := p.(T); ok {
+		return impl, true
+	}
+
+	if adapter, ok := p.(interface{ Unwrap() Processable }); ok {
+		impl, ok := adapter.Unwrap().(T)
+		return impl, ok
+	}
+
+	var zero T
+	return zero, false
+}
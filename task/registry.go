@@ -0,0 +1,75 @@
+package task
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Factory returns a new, empty instance of a Processable implementation which the task payload is unmarshalled into
+type Factory func() Processable
+
+// ContextFactory returns a new, empty instance of a ContextProcessable implementation
+type ContextFactory func() ContextProcessable
+
+var (
+	registryMutex sync.RWMutex
+	registry      = make(map[TypeOf]ContextFactory)
+)
+
+// Register makes a task type available for processing, allowing packages outside of this one to contribute
+// their own Processable implementations. It panics if the type is empty, the factory is nil or the type is
+// already registered, similar to database/sql drivers
+func Register(typeOf TypeOf, factory Factory) {
+	if factory == nil {
+		panic(fmt.Sprintf("task: Register factory is nil for type %s", typeOf))
+	}
+
+	RegisterContext(typeOf, func() ContextProcessable {
+		return AdaptProcessable(factory())
+	})
+}
+
+// RegisterContext makes a context aware task type available for processing, see Register
+func RegisterContext(typeOf TypeOf, factory ContextFactory) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	if typeOf == "" {
+		panic("task: Register task type must be set")
+	}
+
+	if factory == nil {
+		panic(fmt.Sprintf("task: Register factory is nil for type %s", typeOf))
+	}
+
+	if _, dup := registry[typeOf]; dup {
+		panic(fmt.Sprintf("task: Register called twice for type %s", typeOf))
+	}
+
+	registry[typeOf] = factory
+}
+
+// RegisteredTypes returns a sorted list of all the currently registered task types
+func RegisteredTypes() []TypeOf {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	types := make([]TypeOf, 0, len(registry))
+	for typeOf := range registry {
+		types = append(types, typeOf)
+	}
+
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+
+	return types
+}
+
+// lookupFactory returns the registered factory for the type if it exists
+func lookupFactory(typeOf TypeOf) (ContextFactory, bool) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	factory, ok := registry[typeOf]
+	return factory, ok
+}
@@ -0,0 +1,130 @@
+package task
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// Package task/result deals with the results tasks produce and templating them into the payload of dependent tasks
+
+// Resulter is implemented by tasks which produce a result, Result is called once the task processed successfully
+// and the returned value is stored as json
+type Resulter interface {
+	Result() any
+}
+
+// ResultOf returns the json result of a processed task, nil when the task does not produce one
+func ResultOf(p ContextProcessable) (json.RawMessage, error) {
+	resulter, ok := implements[Resulter](p)
+	if !ok {
+		return nil, nil
+	}
+
+	result := resulter.Result()
+	if result == nil {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal task result: %v", err)
+	}
+
+	return data, nil
+}
+
+// ResultData is what the payload of a dependent task is rendered with,
+// e.g. {"body": "Report ready at {{.Results.report.location}}"}
+type ResultData struct {
+	Results map[string]any // results of the dependencies by their workflow key
+}
+
+// RenderPayload renders every string in the payload containing a template action with the results of the
+// dependencies and parses the rendered payload into the task implementation again
+func (t *Task) RenderPayload(results map[string]json.RawMessage) error {
+	if !bytes.Contains(t.Payload, []byte("{{")) {
+		return nil
+	}
+
+	data := ResultData{Results: make(map[string]any, len(results))}
+	for key, result := range results {
+		var value any
+		if err := json.Unmarshal(result, &value); err != nil {
+			return fmt.Errorf("failed to unmarshal result of dependency %s: %v", key, err)
+		}
+		data.Results[key] = value
+	}
+
+	var payload any
+	if err := json.Unmarshal(t.Payload, &payload); err != nil {
+		return fmt.Errorf("failed to unmarshal payload: %v", err)
+	}
+
+	// Only the string values are rendered so the results never have to be escaped for json
+	rendered, err := renderValue(payload, data)
+	if err != nil {
+		return err
+	}
+
+	if t.Payload, err = json.Marshal(rendered); err != nil {
+		return fmt.Errorf("failed to marshal rendered payload: %v", err)
+	}
+
+	process, err := t.ParseTaskType()
+	if err != nil {
+		return fmt.Errorf("task parsing failed: %v", err)
+	}
+
+	if err := process.ValidateTask(); err != nil {
+		return fmt.Errorf("task payload validation failed: %v", err)
+	}
+
+	t.ProcessableTask = process
+	t.UniqueKey = UniqueKeyOf(process)
+
+	return nil
+}
+
+// renderValue walks the decoded json and renders the strings containing a template action
+func renderValue(value any, data ResultData) (any, error) {
+	switch v := value.(type) {
+	case string:
+		if !strings.Contains(v, "{{") {
+			return v, nil
+		}
+
+		tmpl, err := template.New("payload").Option("missingkey=error").Parse(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid payload template: %v", err)
+		}
+
+		var out strings.Builder
+		if err := tmpl.Execute(&out, data); err != nil {
+			return nil, fmt.Errorf("failed to render payload template: %v", err)
+		}
+		return out.String(), nil
+
+	case []any:
+		for i := range v {
+			var err error
+			if v[i], err = renderValue(v[i], data); err != nil {
+				return nil, err
+			}
+		}
+		return v, nil
+
+	case map[string]any:
+		for key := range v {
+			var err error
+			if v[key], err = renderValue(v[key], data); err != nil {
+				return nil, err
+			}
+		}
+		return v, nil
+	}
+
+	return value, nil
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -22,12 +23,8 @@ type Processable interface {
 type TypeOf string
 
 func isValidTypeOf(typeOf TypeOf) bool {
-	// Unfortunate workaround due to lack of enums in GO
-	switch typeOf {
-	case TypeSendEmail, TypeGenerateReport, TypeCPUProcess:
-		return true
-	}
-	return false
+	_, ok := lookupFactory(typeOf)
+	return ok
 }
 
 // ExecutionPriority enum describing execution priority of the task
@@ -38,26 +35,67 @@ const (
 	High
 )
 
+// DefaultPriorityLevels is the amount of priority levels supported unless configured otherwise, Low and High
+const DefaultPriorityLevels = 2
+
+// priorityLevels is the amount of supported priorities, valid priorities range from Low to priorityLevels - 1
+var priorityLevels atomic.Int32
+
+func init() {
+	priorityLevels.Store(DefaultPriorityLevels)
+}
+
+// SetPriorityLevels sets the amount of supported priority levels, it should be called at startup before tasks
+// are created. Values below 1 are ignored
+func SetPriorityLevels(levels int) {
+	if levels >= 1 {
+		priorityLevels.Store(int32(levels))
+	}
+}
+
+// PriorityLevels returns the amount of supported priority levels
+func PriorityLevels() int {
+	return int(priorityLevels.Load())
+}
+
+// MaxPriority returns the highest supported priority
+func MaxPriority() ExecutionPriority {
+	return ExecutionPriority(PriorityLevels() - 1)
+}
+
 // CurrentStatus enum describing current state of the task
 type CurrentStatus string
 
 const (
 	ProcessingAwaiting      CurrentStatus = "Awaiting enqueue"
+	ProcessingScheduled     CurrentStatus = "Scheduled, awaiting run time"
+	ProcessingBlocked       CurrentStatus = "Blocked, awaiting dependencies"
+	ProcessingRateLimited   CurrentStatus = "Rate limited, awaiting capacity"
 	ProcessingEnqueued      CurrentStatus = "Enqueued, awaiting processing"
 	Processing              CurrentStatus = "Being processed by worker"
 	ProcessingSuccess       CurrentStatus = "Processed successfully"
 	ProcessingAwaitingRetry CurrentStatus = "Awaiting retry"
+	ProcessingTimedOut      CurrentStatus = "Timed out, awaiting retry"
 	ProcessingFailed        CurrentStatus = "Failed to process"
+	ProcessingCancelled     CurrentStatus = "Cancelled"
+	ProcessingSkipped       CurrentStatus = "Skipped, dependency did not succeed"
 )
 
+// IsFinal reports whether the task has stopped for good and won't be processed again without a requeue
+func (s CurrentStatus) IsFinal() bool {
+	return s == ProcessingSuccess || s == ProcessingFailed || s == ProcessingCancelled || s == ProcessingSkipped
+}
+
 type Task struct {
 	Id              string
 	Priority        ExecutionPriority
 	TaskType        TypeOf
 	Status          CurrentStatus
 	BackOffDuration *time.Duration
+	BackOffPolicy   *BackoffPolicy // takes precedence over BackOffDuration when set
+	Timeout         *time.Duration // execution deadline of a single attempt, nil means no deadline
 	Payload         json.RawMessage
-	ProcessableTask Processable
+	ProcessableTask ContextProcessable
 
 	CreatedAt time.Time
 	CreatedBy string
@@ -66,9 +104,35 @@ type Task struct {
 	FinishedAt *time.Time
 
 	Retries      int
+	MaxRetries   *int // overrides the queue max retries when set
 	BackOffUntil *time.Time
+	LastBackOff  time.Duration // wait before the last retry, used by the decorrelated jitter backoff
 	Error        error
 	ErrorDetails string // Used for DB persistence
+
+	Attempts []Attempt       // processing attempts of the task in order
+	Result   json.RawMessage // set once the task processed successfully if its implementation produces a result
+
+	WorkflowId string   // set when the task was submitted as part of a workflow
+	Key        string   // client supplied key of the task within its workflow
+	DependsOn  []string // ids of the tasks which have to succeed before this one runs
+
+	DedupeKey string // repeated submissions of the user with the same key within the dedupe window create no new task
+	UniqueKey string // declared by the task type, only one task of the type with the key can be pending at a time
+
+	Queue string // named queue the task is processed by, empty routes it by its type
+
+	invalidTimeout string // timeout passed in which could not be parsed, reported by the validation
+}
+
+// Attempt describes a single processing attempt of a task
+type Attempt struct {
+	Number     int       `json:"attempt"`
+	WorkerId   string    `json:"workerId"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	DurationMs int64     `json:"durationMs"`
+	Error      string    `json:"error,omitempty"` // empty when the attempt succeeded
 }
 
 type option func(task *Task)
@@ -107,6 +171,85 @@ func WithBackoffTime(backoffDuration string) option {
 	}
 }
 
+// WithBackoffPolicy sets how the wait between retries grows
+func WithBackoffPolicy(policy *BackoffPolicy) option {
+	return func(t *Task) {
+		t.BackOffPolicy = policy
+	}
+}
+
+// WithMaxRetries overrides the amount of times the task is retried, nil keeps the queue default
+func WithMaxRetries(retries *int) option {
+	return func(t *Task) {
+		t.MaxRetries = retries
+	}
+}
+
+// WithTimeout sets the execution deadline of a single processing attempt, an invalid timeout fails the validation
+func WithTimeout(timeout string) option {
+	return func(t *Task) {
+		d, err := ParseTimeout(timeout)
+		if err != nil {
+			t.invalidTimeout = timeout
+			return
+		}
+		t.Timeout = d
+	}
+}
+
+// ParseTimeout parses an execution deadline, an empty timeout is no deadline
+func ParseTimeout(timeout string) (*time.Duration, error) {
+	if timeout == "" {
+		return nil, nil
+	}
+
+	d, err := time.ParseDuration(timeout)
+	if err != nil || d <= 0 {
+		return nil, fmt.Errorf("invalid timeout: %s", timeout)
+	}
+
+	return &d, nil
+}
+
+// WithRunAt delays the first processing attempt of the task until runAt, a time in the past runs it right away
+func WithRunAt(runAt *time.Time) option {
+	return func(t *Task) {
+		if runAt != nil {
+			at := runAt.UTC()
+			t.BackOffUntil = &at
+		}
+	}
+}
+
+// WithWorkflow adds the task to the workflow under the client supplied key
+func WithWorkflow(workflowId string, key string) option {
+	return func(t *Task) {
+		t.WorkflowId = workflowId
+		t.Key = key
+	}
+}
+
+// WithDependsOn holds the task until every task of the ids processed successfully
+func WithDependsOn(ids []string) option {
+	return func(t *Task) {
+		t.DependsOn = ids
+	}
+}
+
+// WithDedupeKey sets the key repeated submissions of the same task are recognised by
+func WithDedupeKey(key string) option {
+	return func(t *Task) {
+		t.DedupeKey = key
+	}
+}
+
+// WithQueue sets the named queue the task is processed by
+func WithQueue(queue string) option {
+	return func(t *Task) {
+		t.Queue = queue
+	}
+}
+
 // WithPayload sets created by user id
 func WithPayload(payload json.RawMessage) option {
 	return func(t *Task) {
@@ -128,6 +271,16 @@ func CreateTask(opts ...option) (*Task, error) {
 		opt(t)
 	}
 
+	// A task due in the future sits in the awaiting queue until its run time the same way a backed off task does
+	if t.BackOffUntil != nil && t.BackOffUntil.After(t.CreatedAt) {
+		t.Status = ProcessingScheduled
+	}
+
+	// Dependencies are checked first, a scheduled task still waits for its run time once they succeeded
+	if len(t.DependsOn) > 0 {
+		t.Status = ProcessingBlocked
+	}
+
 	if err := t.validateTask(); err != nil {
 		return nil, fmt.Errorf("task validation failed: %v", err)
 	}
@@ -142,6 +295,7 @@ func CreateTask(opts ...option) (*Task, error) {
 	}
 
 	t.ProcessableTask = process
+	t.UniqueKey = UniqueKeyOf(process)
 
 	return t, nil
 }
@@ -160,32 +314,57 @@ func (t *Task) validateTask() error {
 		return fmt.Errorf("creator user id must be set")
 	}
 
-	if t.Priority < Low || t.Priority > High {
+	if t.Priority < Low || t.Priority > MaxPriority() {
 		return fmt.Errorf("unsupported priority")
 	}
 
+	if t.invalidTimeout != "" {
+		return fmt.Errorf("invalid timeout: %s", t.invalidTimeout)
+	}
+
+	if t.MaxRetries != nil && *t.MaxRetries < 0 {
+		return fmt.Errorf("max retries can't be negative")
+	}
+
+	if t.BackOffPolicy != nil {
+		if err := t.BackOffPolicy.Validate(); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-// ParseTaskType parses the task payload into the correct type which implements the Processable interface
-func (t *Task) ParseTaskType() (Processable, error) {
-	var payload interface{}
+// NextBackOff computes the wait before the current retry from the backoff policy and remembers it,
+// a task with only a backoff duration waits that duration every time. Returns false when the task has no backoff
+func (t *Task) NextBackOff() (time.Duration, bool) {
+	policy := t.BackOffPolicy
+	if policy == nil {
+		if t.BackOffDuration == nil {
+			return 0, false
+		}
+		policy = &BackoffPolicy{Strategy: BackoffConstant, Base: *t.BackOffDuration}
+	}
 
-	switch t.TaskType {
-	case TypeSendEmail:
-		payload = new(SendEmail)
-	case TypeGenerateReport:
-		payload = new(GenerateReport)
-	case TypeCPUProcess:
-		payload = new(CPUProcess)
-	default:
+	wait := policy.Next(t.Retries, t.LastBackOff)
+	t.LastBackOff = wait
+
+	return wait, true
+}
+
+// ParseTaskType parses the task payload into the registered type which implements the ContextProcessable interface
+func (t *Task) ParseTaskType() (ContextProcessable, error) {
+	factory, ok := lookupFactory(t.TaskType)
+	if !ok {
 		return nil, errors.New("unsupported data type")
 	}
 
+	payload := factory()
+
 	err := json.Unmarshal(t.Payload, payload)
 	if err != nil {
 		return nil, errors.New("failed to unmarshal task data payload")
 	}
 
-	return payload.(Processable), nil
+	return payload, nil
 }
@@ -1,18 +1,35 @@
 package task
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"time"
 )
 
-const TypeCPUProcess     TypeOf = "CPUProcess"
+const TypeCPUProcess TypeOf = "CPUProcess"
+
+func init() {
+	RegisterContext(TypeCPUProcess, func() ContextProcessable { return new(CPUProcess) })
+}
 
 // CPUProcess simulates CPU processing time
 type CPUProcess struct {
 	ProcessType string `json:"processType"`
+	Duration    string `json:"duration,omitempty"` // simulated processing time
 }
 
-func (t *CPUProcess) ProcessTask() error {
+func (t *CPUProcess) ProcessTaskContext(ctx context.Context) error {
+	if t.Duration != "" {
+		d, _ := time.ParseDuration(t.Duration)
+
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
 	return fmt.Errorf("Error while processing task due to proces type failure: %s", t.ProcessType)
 }
 
@@ -20,5 +37,11 @@ func (t *CPUProcess) ValidateTask() error {
 	if t.ProcessType == "" {
 		return errors.New("process type can't be empty")
 	}
+
+	if t.Duration != "" {
+		if _, err := time.ParseDuration(t.Duration); err != nil {
+			return errors.New("invalid duration")
+		}
+	}
 	return nil
 }
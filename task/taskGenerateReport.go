@@ -1,26 +1,66 @@
 package task
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 )
 
 const TypeGenerateReport TypeOf = "GenerateReport"
 
+func init() {
+	RegisterContext(TypeGenerateReport, func() ContextProcessable { return new(GenerateReport) })
+}
+
 // GenerateReport simulates generating a report
 type GenerateReport struct {
 	Notify     []string `json:"notify"`
 	ReportType string   `json:"reportType" json:"reportType"`
+	Date       string   `json:"date,omitempty"` // day the report covers as YYYY-MM-DD
+
+	location string // where the generated report was stored
 }
 
-func (t *GenerateReport) ProcessTask() error {
-	fmt.Printf("Report : %s generated, notifying %s \n", t.ReportType, t.Notify)
+// GenerateReportResult is the result of a generated report, dependent tasks can refer to it as {{.Results.<key>.location}}
+type GenerateReportResult struct {
+	Location string `json:"location"`
+}
+
+func (t *GenerateReport) ProcessTaskContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	t.location = fmt.Sprintf("reports/%s/%s.pdf",
+		strings.ReplaceAll(strings.ToLower(t.ReportType), " ", "-"), time.Now().UTC().Format("2006-01-02T150405"))
+	fmt.Printf("Report : %s generated at %s, notifying %s \n", t.ReportType, t.location, t.Notify)
 	return nil
 }
 
+func (t *GenerateReport) Result() any {
+	return GenerateReportResult{Location: t.location}
+}
+
+// UniqueKey makes a report of the same type covering the same day the same report, a report without a date is
+// not deduplicated
+func (t *GenerateReport) UniqueKey() string {
+	if t.Date == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s:%s", t.ReportType, t.Date)
+}
+
 func (t *GenerateReport) ValidateTask() error {
 	if t.ReportType == "" {
 		return errors.New("unsupported report type")
 	}
+
+	if t.Date != "" {
+		if _, err := time.Parse(time.DateOnly, t.Date); err != nil {
+			return errors.New("date must be formatted as YYYY-MM-DD")
+		}
+	}
 	return nil
 }
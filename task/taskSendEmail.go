@@ -1,11 +1,17 @@
 package task
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net/mail"
 )
 
-const TypeSendEmail      TypeOf = "SendEmail"
+const TypeSendEmail TypeOf = "SendEmail"
+
+func init() {
+	RegisterContext(TypeSendEmail, func() ContextProcessable { return new(SendEmail) })
+}
 
 // SendEmail simulates sending a email
 type SendEmail struct {
@@ -15,7 +21,19 @@ type SendEmail struct {
 	Body     string   `json:"body"`
 }
 
-func (t *SendEmail) ProcessTask() error {
+func (t *SendEmail) ProcessTaskContext(ctx context.Context) error {
+	// The relay would reject these no matter how many times we retry
+	for _, recipient := range t.SendTo {
+		if _, err := mail.ParseAddress(recipient); err != nil {
+			return Permanent(fmt.Errorf("invalid recipient %s", recipient))
+		}
+	}
+
+	// Nothing is sent once the deadline passed so a retry can't send the email twice
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	fmt.Printf("Email sent from : %s to : %s , subject: %s \n", t.SendFrom, t.SendTo, t.Subject)
 	return nil
 }
@@ -0,0 +1,17 @@
+package task
+
+// Package task/unique deals with task types declaring when two of their tasks are the same piece of work
+
+// Uniquer is implemented by tasks which should not be pending more than once, tasks of the same type with the same
+// unique key are considered the same work, e.g. a report of the same type on the same day
+type Uniquer interface {
+	UniqueKey() string
+}
+
+// UniqueKeyOf returns the unique key of the task implementation, empty when the task does not declare one
+func UniqueKeyOf(p ContextProcessable) string {
+	if uniquer, ok := implements[Uniquer](p); ok {
+		return uniquer.UniqueKey()
+	}
+	return ""
+}